@@ -12,6 +12,8 @@ import (
 	"time"
 
 	"github.com/serg2014/go-musthave-diploma/internal/app"
+	"github.com/serg2014/go-musthave-diploma/internal/app/accrual"
+	"github.com/serg2014/go-musthave-diploma/internal/app/tracing"
 	"github.com/serg2014/go-musthave-diploma/internal/config"
 	"github.com/serg2014/go-musthave-diploma/internal/logger"
 	"go.uber.org/zap"
@@ -25,7 +27,26 @@ func main() {
 	if err := logger.Initialize(cnf.LogLevel); err != nil {
 		log.Fatal(err)
 	}
-	a, err := app.NewApp(cnf)
+	shutdownTracing, err := tracing.Init(context.Background(), cnf.TraceExporter, cnf.TraceOTLPEndpoint)
+	if err != nil {
+		logger.Log.Fatal("error init tracing", zap.Error(err))
+	}
+	defer func() {
+		// досылаем накопленные спаны
+		ctxT, cancelT := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancelT()
+		if err := shutdownTracing(ctxT); err != nil {
+			logger.Log.Error("failed shutdown tracing", zap.Error(err))
+		}
+	}()
+
+	accrualClient := accrual.NewHTTPClient(
+		cnf.AccrualAddress,
+		cnf.AccrualTimeout,
+		cnf.Workers,
+		accrual.NewBreaker(cnf.AccrualBreakerThreshold, cnf.AccrualBreakerCooldown),
+	)
+	a, err := app.NewApp(cnf, accrualClient)
 	if err != nil {
 		logger.Log.Fatal("error NewApp", zap.Error(err))
 	}
@@ -52,6 +73,12 @@ func main() {
 			} else {
 				logger.Log.Debug("cleanup ok")
 			}
+			if err := a.CleanupIdempotency(ctx); err != nil {
+				logger.Log.Error("failed cleanup idempotency keys", zap.Error(err))
+			}
+			if err := a.CleanupUserEvents(ctx); err != nil {
+				logger.Log.Error("failed cleanup user events", zap.Error(err))
+			}
 			select {
 			case <-ticker.C:
 			case <-ctx.Done():
@@ -69,6 +96,64 @@ func main() {
 		logger.Log.Info("Stop processed goroutine")
 	}()
 
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(cnf.PointsExpireInterval)
+		defer ticker.Stop()
+		for {
+			n, err := a.ExpirePoints(ctx)
+			if err != nil {
+				logger.Log.Error("failed expire points", zap.Error(err))
+			} else if n > 0 {
+				logger.Log.Info("points expired", zap.Int("accruals", n))
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				logger.Log.Info("Stop points expiration goroutine")
+				return
+			}
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(cnf.HoldReleaseInterval)
+		defer ticker.Stop()
+		for {
+			n, err := a.ReleaseStaleHolds(ctx)
+			if err != nil {
+				logger.Log.Error("failed release stale holds", zap.Error(err))
+			} else if n > 0 {
+				logger.Log.Info("stale holds released", zap.Int("holds", n))
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				logger.Log.Info("Stop holds release goroutine")
+				return
+			}
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		a.DeliverWebhooks(ctx)
+		logger.Log.Info("Stop webhooks goroutine")
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		a.DispatchUserEvents(ctx)
+		logger.Log.Info("Stop user events goroutine")
+	}()
+
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
@@ -80,6 +165,10 @@ func main() {
 		// 	ждем сигнала от ОС
 		case <-ctxS.Done():
 			logger.Log.Info("catch signal")
+			// readyz отвечает 503, даем балансировщику время убрать нас из ротации
+			a.SetShuttingDown()
+			logger.Log.Info("drain before shutdown", zap.Duration("drain", cnf.ShutdownDrain))
+			time.Sleep(cnf.ShutdownDrain)
 		// ждем отмены контекста
 		case <-ctx.Done():
 			logger.Log.Info("stop")
@@ -0,0 +1,141 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/serg2014/go-musthave-diploma/internal/logger"
+	"go.uber.org/zap"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Breaker после threshold подряд ошибок (500, таймауты, сеть) перестает
+// пускать запросы в accrual на cooldown. Потом пропускает один пробный запрос:
+// успех закрывает breaker, ошибка снова открывает.
+type Breaker struct {
+	mu        sync.Mutex
+	state     breakerState
+	failures  int
+	openedAt  time.Time
+	threshold int
+	cooldown  time.Duration
+	// пробный запрос в полуоткрытом состоянии уже выполняется
+	probing bool
+}
+
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// Allow можно ли сейчас делать запрос
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		b.setState(breakerHalfOpen)
+		b.probing = true
+		return nil
+	case breakerHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+		return nil
+	}
+	return nil
+}
+
+// Report результат запроса, разрешенного Allow
+func (b *Breaker) Report(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	// запрос отменил вызывающий, о сервисе он ничего не говорит
+	if isDoneContext(err) {
+		return
+	}
+	if !IsFailure(err) {
+		b.failures = 0
+		if b.state != breakerClosed {
+			b.setState(breakerClosed)
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		b.setState(breakerOpen)
+	}
+}
+
+// setState вызывать под мьютексом
+func (b *Breaker) setState(state breakerState) {
+	if b.state == state {
+		return
+	}
+	logger.Log.Info(
+		"accrual circuit breaker",
+		zap.Stringer("from", b.state),
+		zap.Stringer("to", state),
+		zap.Int("failures", b.failures),
+	)
+	b.state = state
+}
+
+// isDoneContext запрос прерван контекстом вызывающего.
+// Таймаут http клиента geturl превращает в ErrTimeout, поэтому
+// DeadlineExceeded здесь - это дедлайн родительского контекста
+func isDoneContext(err error) bool {
+	return errors.Is(err, ErrDoneContext) ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
+
+// IsFailure признаки того что сервис недоступен.
+// 204, 429 и прочие ответы значат что сервис жив
+func IsFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrHTTPInternalServerError) || errors.Is(err, ErrTimeout) {
+		return true
+	}
+	// *url.Error от отмены контекста тоже net.Error, но сервис тут ни при чем
+	if isDoneContext(err) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
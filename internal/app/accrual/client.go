@@ -0,0 +1,14 @@
+package accrual
+
+import (
+	"context"
+
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+)
+
+// Client система расчета баллов лояльности
+type Client interface {
+	// GetAccrual возвращает статус и начисление по заказу.
+	// ErrHTTPNoContent - заказ не зарегистрирован в системе расчета
+	GetAccrual(ctx context.Context, orderID models.OrderID) (*models.AccrualOrderItem, error)
+}
@@ -0,0 +1,33 @@
+package accrual
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrHTTPNoContent           = errors.New("http 204")
+	ErrHTTPNTooManyRequets     = errors.New("http 429")
+	ErrHTTPInternalServerError = errors.New("http 500")
+	ErrHTTPOther               = errors.New("http other")
+	ErrTimeout                 = errors.New("timeout")
+	ErrContext                 = errors.New("error context")
+	ErrDoneContext             = errors.New("done context")
+	ErrCircuitOpen             = errors.New("circuit breaker is open")
+)
+
+// TooManyRequestsError ответ 429 с разобранными Retry-After и лимитом
+type TooManyRequestsError struct {
+	RetryAfter time.Duration
+	// 0 если лимит не удалось разобрать
+	PerMinute int
+}
+
+func (e *TooManyRequestsError) Error() string {
+	return fmt.Sprintf("%s: retry after %s, limit %d per minute", ErrHTTPNTooManyRequets, e.RetryAfter, e.PerMinute)
+}
+
+func (e *TooManyRequestsError) Unwrap() error {
+	return ErrHTTPNTooManyRequets
+}
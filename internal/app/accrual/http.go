@@ -0,0 +1,179 @@
+package accrual
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/serg2014/go-musthave-diploma/internal/app/metrics"
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+	"github.com/serg2014/go-musthave-diploma/internal/app/tracing"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ordersPath путь к заказу в accrual: /api/orders/{number}
+const ordersPath = "/api/orders/"
+
+// HTTPClient ходит в accrual по http. Один пул соединений на все воркеры
+type HTTPClient struct {
+	address string
+	client  *http.Client
+	limiter *Limiter
+	breaker *Breaker
+}
+
+func NewHTTPClient(address string, timeout time.Duration, maxConns int, breaker *Breaker) *HTTPClient {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = maxConns
+	transport.MaxConnsPerHost = maxConns
+	return &HTTPClient{
+		address: strings.TrimRight(address, "/"),
+		client: &http.Client{
+			Timeout:   timeout,
+			Transport: transport,
+		},
+		limiter: NewLimiter(),
+		breaker: breaker,
+	}
+}
+
+func (c *HTTPClient) GetAccrual(ctx context.Context, orderID models.OrderID) (*models.AccrualOrderItem, error) {
+	endpoint := c.address + ordersPath + url.PathEscape(orderID)
+	return c.geturlWithRetries(ctx, endpoint)
+}
+
+func (c *HTTPClient) geturlWithRetries(ctx context.Context, endpoint string) (*models.AccrualOrderItem, error) {
+	retry := []time.Duration{
+		1500 * time.Millisecond,
+		3000 * time.Millisecond,
+		0 * time.Millisecond,
+	}
+
+	var (
+		data *models.AccrualOrderItem
+		err  error
+	)
+	for _, dur := range retry {
+		// общая на все воркеры очередь запросов
+		if err := c.limiter.Wait(ctx); err != nil {
+			return nil, ErrDoneContext
+		}
+		// сервис лежит - не тратим время воркера
+		if err := c.breaker.Allow(); err != nil {
+			metrics.AccrualRequest("circuit_open")
+			return nil, err
+		}
+		data, err = c.geturl(ctx, endpoint)
+		c.breaker.Report(err)
+		if err == nil {
+			break
+		}
+		var tooMany *TooManyRequestsError
+		if errors.As(err, &tooMany) {
+			// пауза для всех воркеров, повтор дождется ее окончания в limiter.Wait
+			c.limiter.Throttle(tooMany)
+			continue
+		}
+		// TODO как поймать timeout?
+		// in geturl err: Get "http://localhost:8080/api/orders/32": context deadline exceeded (Client.Timeout exceeded while awaiting headers)
+		// data: <nil> error: failed get
+		// data: <nil> error: bad json: EOF - нет тела
+		// тут таймаут на получении тела
+		// data: <nil> error: bad json: context deadline exceeded (Client.Timeout or context cancellation while reading body)
+		if errors.Is(err, ErrTimeout) ||
+			errors.Is(err, ErrHTTPInternalServerError) {
+			timeout := time.After(dur)
+			select {
+			case <-timeout:
+				continue
+			case <-ctx.Done():
+				return nil, ErrDoneContext
+			}
+		}
+		return nil, err
+	}
+
+	return data, err
+}
+
+// geturl одна попытка, отдельный спан на каждую
+func (c *HTTPClient) geturl(ctx context.Context, endpoint string) (_ *models.AccrualOrderItem, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "accrual GET",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.URLFull(endpoint)),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, ErrContext
+	}
+	tracing.Inject(ctx, req.Header)
+	response, err := c.client.Do(req)
+	if err != nil {
+		// отменили мы сами, а не accrual не ответил
+		if ctx.Err() != nil {
+			metrics.AccrualRequest("canceled")
+			return nil, ErrDoneContext
+		}
+		if os.IsTimeout(err) {
+			metrics.AccrualRequest("timeout")
+			return nil, ErrTimeout
+		}
+		metrics.AccrualRequest("error")
+		return nil, fmt.Errorf("failed get: %w", err)
+	}
+	defer response.Body.Close()
+	metrics.AccrualRequest(strconv.Itoa(response.StatusCode))
+	span.SetAttributes(semconv.HTTPResponseStatusCode(response.StatusCode))
+
+	if response.StatusCode == http.StatusTooManyRequests {
+		// No more than N requests per minute allowed
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return nil, &TooManyRequestsError{
+			RetryAfter: ParseRetryAfter(response.Header.Get("Retry-After")),
+			PerMinute:  ParsePerMinute(body),
+		}
+	}
+
+	statusToError := map[int]error{
+		http.StatusOK:                  nil,
+		http.StatusNoContent:           ErrHTTPNoContent,
+		http.StatusInternalServerError: ErrHTTPInternalServerError,
+	}
+	err, ok := statusToError[response.StatusCode]
+	switch {
+	case ok:
+	case response.StatusCode > http.StatusInternalServerError:
+		// 502, 503, 504 - сервис недоступен так же, как при 500
+		err = ErrHTTPInternalServerError
+	default:
+		err = ErrHTTPOther
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data := models.AccrualOrderItem{}
+	dec := json.NewDecoder(response.Body)
+	if err := dec.Decode(&data); err != nil {
+		if ctx.Err() != nil {
+			return nil, ErrDoneContext
+		}
+		if os.IsTimeout(err) {
+			return nil, ErrTimeout
+		}
+		return nil, fmt.Errorf("bad json: %w", err)
+	}
+
+	return &data, nil
+}
@@ -0,0 +1,68 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"ok", nil, false},
+		{"500", ErrHTTPInternalServerError, true},
+		{"timeout", ErrTimeout, true},
+		{"network", &url.Error{Op: "Get", URL: "http://accrual", Err: errors.New("connection refused")}, true},
+		{"no content", ErrHTTPNoContent, false},
+		{"done context", ErrDoneContext, false},
+		{"canceled", &url.Error{Op: "Get", URL: "http://accrual", Err: context.Canceled}, false},
+		{"deadline", fmt.Errorf("failed get: %w", context.DeadlineExceeded), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsFailure(tt.err))
+		})
+	}
+}
+
+func TestGetAccrualCanceled(t *testing.T) {
+	// accrual отвечает дольше, чем ждет вызывающий
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	breaker := NewBreaker(1, time.Hour)
+	c := NewHTTPClient(server.URL, 10*time.Second, 1, breaker)
+	for _, name := range []string{"canceled", "deadline"} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			if name == "canceled" {
+				go func() {
+					time.Sleep(10 * time.Millisecond)
+					cancel()
+				}()
+			}
+			_, err := c.GetAccrual(ctx, "12345678903")
+			assert.ErrorIs(t, err, ErrDoneContext)
+			// отмена не открывает breaker даже при пороге в одну ошибку
+			require.NoError(t, breaker.Allow())
+			breaker.Report(nil)
+		})
+	}
+}
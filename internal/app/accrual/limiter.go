@@ -0,0 +1,158 @@
+package accrual
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/serg2014/go-musthave-diploma/internal/logger"
+	"go.uber.org/zap"
+)
+
+// DefaultRetryAfter если сервис ответил 429 без Retry-After
+const DefaultRetryAfter = 60 * time.Second
+
+// тело ответа 429: "No more than N requests per minute allowed"
+var limitRe = regexp.MustCompile(`No more than (\d+) requests per minute`)
+
+// Limiter общий для всех воркеров ограничитель запросов в accrual.
+// После 429 ставит всех на паузу на Retry-After и дальше
+// пропускает запросы не чаще лимита из тела ответа.
+type Limiter struct {
+	mu sync.Mutex
+	// до какого времени никого не пускаем
+	pausedUntil time.Time
+	paused      bool
+	// запросов в минуту, 0 - без ограничения
+	perMinute int
+	// минимальный интервал между запросами
+	interval time.Duration
+	// время следующего свободного слота
+	next time.Time
+}
+
+func NewLimiter() *Limiter {
+	return &Limiter{}
+}
+
+// Wait ждет своей очереди на запрос. Слот занимаем только после ожидания:
+// отмененный запрос не должен задерживать остальных воркеров
+func (l *Limiter) Wait(ctx context.Context) error {
+	for {
+		slot, ok := l.reserve()
+		if ok {
+			return nil
+		}
+		timer := time.NewTimer(time.Until(slot))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// reserve занимает слот, если он уже наступил. Иначе возвращает, когда он освободится
+func (l *Limiter) reserve() (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if l.paused && !now.Before(l.pausedUntil) {
+		l.paused = false
+		logger.Log.Info("accrual throttle: pause is over", zap.Int("per_minute", l.perMinute))
+	}
+	slot := now
+	if slot.Before(l.pausedUntil) {
+		slot = l.pausedUntil
+	}
+	if slot.Before(l.next) {
+		slot = l.next
+	}
+	if slot.After(now) {
+		return slot, false
+	}
+	l.next = now.Add(l.interval)
+	return now, true
+}
+
+// Pause останавливает все запросы на d
+func (l *Limiter) Pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	until := time.Now().Add(d)
+	if !until.After(l.pausedUntil) {
+		return
+	}
+	l.pausedUntil = until
+	l.paused = true
+	// после паузы начинаем с чистого листа
+	l.next = until
+	logger.Log.Info(
+		"accrual throttle: pause",
+		zap.Duration("retry_after", d),
+		zap.Time("until", until),
+		zap.Int("per_minute", l.perMinute),
+	)
+}
+
+// SetRate задает лимит запросов в минуту
+func (l *Limiter) SetRate(perMinute int) {
+	if perMinute <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.perMinute == perMinute {
+		return
+	}
+	l.perMinute = perMinute
+	l.interval = time.Minute / time.Duration(perMinute)
+	logger.Log.Info(
+		"accrual throttle: set rate",
+		zap.Int("per_minute", perMinute),
+		zap.Duration("interval", l.interval),
+	)
+}
+
+// Throttle применяет ответ 429
+func (l *Limiter) Throttle(err *TooManyRequestsError) {
+	l.SetRate(err.PerMinute)
+	l.Pause(err.RetryAfter)
+}
+
+// ParseRetryAfter поддерживает оба формата: секунды и HTTP-дату
+func ParseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return DefaultRetryAfter
+	}
+	if sec, err := strconv.Atoi(value); err == nil && sec >= 0 {
+		return time.Duration(sec) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+		return 0
+	}
+	return DefaultRetryAfter
+}
+
+// ParsePerMinute достает лимит из тела ответа 429, 0 если не нашли
+func ParsePerMinute(body []byte) int {
+	m := limitRe.FindSubmatch(body)
+	if m == nil {
+		return 0
+	}
+	n, err := strconv.Atoi(string(m[1]))
+	if err != nil {
+		return 0
+	}
+	return n
+}
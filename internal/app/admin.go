@@ -0,0 +1,247 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/serg2014/go-musthave-diploma/internal/app/auth"
+	usercontext "github.com/serg2014/go-musthave-diploma/internal/app/context"
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+	"github.com/serg2014/go-musthave-diploma/internal/app/problem"
+	"github.com/serg2014/go-musthave-diploma/internal/app/storage"
+	"github.com/serg2014/go-musthave-diploma/internal/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	deadLettersDefaultLimit = 100
+	deadLettersMaxLimit     = 1000
+	reversalReasonMaxLen    = 1000
+	adjustReasonMaxLen      = 1000
+)
+
+func (a *App) setAdminRoute(r chi.Router) {
+	r.Route("/api/admin", func(r chi.Router) {
+		r.Group(func(r chi.Router) {
+			r.Use(a.admins.AdminMiddleware)
+			r.Get("/orders/dead", a.listDeadLetters())
+			r.Post("/orders/dead/{number}/requeue", a.requeueDeadLetter())
+			r.Post("/users/{login}/adjustments", a.adjustBalance())
+			r.Get("/users/{login}/adjustments", a.listAdjustments(true))
+		})
+		// заказом, оплаченным баллами, управляет и сам магазин
+		r.Group(func(r chi.Router) {
+			r.Use(a.admins.RoleMiddleware(auth.RoleAdmin, auth.RoleMerchant))
+			r.Post("/withdrawals/{number}/reverse", a.reverseWithdrawal())
+			r.Post("/holds/{number}/capture", a.captureHold())
+			r.Post("/holds/{number}/release", a.releaseHold())
+		})
+	})
+}
+
+func (a *App) listDeadLetters() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := uint64(deadLettersDefaultLimit)
+		if v := r.URL.Query().Get("limit"); v != "" {
+			var err error
+			limit, err = strconv.ParseUint(v, 10, 32)
+			if err != nil || limit == 0 || limit > deadLettersMaxLimit {
+				problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeInvalidQuery, "bad limit"))
+				return
+			}
+		}
+		data, err := a.store.ListDeadLetters(r.Context(), uint(limit))
+		if err != nil {
+			problem.Error(w, r, err, "failed ListDeadLetters")
+			return
+		}
+		if len(data) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, data)
+	}
+}
+
+// requeueDeadLetter возвращает заказ в обработку с нулевым счетчиком попыток
+func (a *App) requeueDeadLetter() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		orderID := chi.URLParam(r, "number")
+		err := a.store.RequeueDeadLetter(r.Context(), orderID)
+		if err != nil {
+			problem.Error(w, r, err, "failed RequeueDeadLetter")
+			return
+		}
+		admin, _ := usercontext.GetAdmin(r.Context())
+		logger.Log.Info("dead letter requeued", zap.String("orderID", orderID), zap.String("admin", admin))
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// reverseWithdrawal отменяет списание баллов за заказ, баллы возвращаются пользователю.
+// Тело с причиной необязательно
+func (a *App) reverseWithdrawal() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req models.ReversalRequest
+		dec := json.NewDecoder(r.Body)
+		if err := dec.Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeBadJSON, err.Error()))
+			return
+		}
+		if len(req.Reason) > reversalReasonMaxLen {
+			problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeBadRequest, "reason is too long"))
+			return
+		}
+		admin, _ := usercontext.GetAdmin(r.Context())
+		reversal := models.Reversal{
+			OrderID:    chi.URLParam(r, "number"),
+			ReversedBy: admin,
+			Reason:     req.Reason,
+		}
+		if err := a.store.ReverseWithdrawal(r.Context(), &reversal); err != nil {
+			problem.Error(w, r, err, "failed ReverseWithdrawal")
+			return
+		}
+		logger.Log.Info("withdrawal reversed", zap.String("orderID", reversal.OrderID),
+			zap.String("user_id", reversal.UserID.String()), zap.String("admin", admin))
+		writeJSON(w, reversal)
+	}
+}
+
+// captureHold списывает зарезервированные баллы, когда заказ оплачен
+func (a *App) captureHold() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		orderID := chi.URLParam(r, "number")
+		hold, err := a.store.CaptureHold(r.Context(), orderID)
+		if err != nil {
+			problem.Error(w, r, err, "failed CaptureHold")
+			return
+		}
+		admin, _ := usercontext.GetAdmin(r.Context())
+		logger.Log.Info("hold captured", zap.String("orderID", orderID), zap.String("admin", admin))
+		writeJSON(w, hold)
+	}
+}
+
+// releaseHold возвращает зарезервированные баллы, когда заказ не состоялся
+func (a *App) releaseHold() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		orderID := chi.URLParam(r, "number")
+		hold, err := a.store.ReleaseHold(r.Context(), orderID)
+		if err != nil {
+			problem.Error(w, r, err, "failed ReleaseHold")
+			return
+		}
+		admin, _ := usercontext.GetAdmin(r.Context())
+		logger.Log.Info("hold released", zap.String("orderID", orderID), zap.String("admin", admin))
+		writeJSON(w, hold)
+	}
+}
+
+// adminUser пользователь из адреса admin api
+func (a *App) adminUser(w http.ResponseWriter, r *http.Request) (*storage.User, bool) {
+	user, err := a.store.GetUser(r.Context(), chi.URLParam(r, "login"))
+	if err != nil {
+		if errors.Is(err, storage.ErrUserOrPassword) {
+			problem.Write(w, r, problem.New(http.StatusNotFound, problem.CodeNotFound, "user not found"))
+			return nil, false
+		}
+		problem.Error(w, r, err, "failed GetUser")
+		return nil, false
+	}
+	return user, true
+}
+
+// adjustBalance ручное начисление или списание баллов с обязательной причиной.
+// Кто исправил баланс, сохраняется вместе с корректировкой
+func (a *App) adjustBalance() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req models.AdjustmentRequest
+		dec := json.NewDecoder(r.Body)
+		if err := dec.Decode(&req); err != nil {
+			problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeBadJSON, err.Error()))
+			return
+		}
+		if req.Sum == 0 {
+			problem.Write(w, r, problem.New(http.StatusUnprocessableEntity, problem.CodeInvalidSum, "sum must not be zero"))
+			return
+		}
+		req.Reason = strings.TrimSpace(req.Reason)
+		if req.Reason == "" {
+			problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeBadRequest, "reason is required"))
+			return
+		}
+		if len(req.Reason) > adjustReasonMaxLen {
+			problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeBadRequest, "reason is too long"))
+			return
+		}
+		user, ok := a.adminUser(w, r)
+		if !ok {
+			return
+		}
+		admin, _ := usercontext.GetAdmin(r.Context())
+		adj := models.Adjustment{
+			UserID: user.ID,
+			Sum:    req.Sum,
+			Reason: req.Reason,
+			Admin:  admin,
+		}
+		if err := a.store.AdjustBalance(r.Context(), &adj, a.config.PointsTTL); err != nil {
+			problem.Error(w, r, err, "failed AdjustBalance")
+			return
+		}
+		logger.Log.Info("balance adjusted", zap.String("adjustment_id", adj.ID.String()),
+			zap.String("user_id", user.ID.String()), zap.Stringer("sum", adj.Sum), zap.String("admin", admin))
+		writeJSON(w, adj)
+	}
+}
+
+// listAdjustments история корректировок. Администратор видит, кто их сделал,
+// пользователь - только сумму и причину
+func (a *App) listAdjustments(forAdmin bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var userID models.UserID
+		if forAdmin {
+			user, ok := a.adminUser(w, r)
+			if !ok {
+				return
+			}
+			userID = user.ID
+		} else {
+			id, err := usercontext.GetUserID(r.Context())
+			if err != nil {
+				problem.Status(w, r, http.StatusUnauthorized, problem.CodeUnauthorized)
+				return
+			}
+			userID = *id
+		}
+		filter, err := parseListFilter(r, false)
+		if err != nil {
+			problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeInvalidQuery, err.Error()))
+			return
+		}
+		data, err := a.store.Adjustments(r.Context(), userID, fetchLimit(filter))
+		if err != nil {
+			problem.Error(w, r, err, "failed Adjustments")
+			return
+		}
+		data = setNextCursor(w, data, filter, func(adj models.Adjustment) models.Cursor {
+			return models.Cursor{Time: adj.CreatedAt, ID: adj.ID.String()}
+		})
+		if len(data) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if !forAdmin {
+			for i := range data {
+				data[i].Admin = ""
+			}
+		}
+		writeJSON(w, data)
+	}
+}
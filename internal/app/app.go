@@ -6,20 +6,24 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/serg2014/go-musthave-diploma/internal/app/accrual"
+	"github.com/serg2014/go-musthave-diploma/internal/app/auth"
+	"github.com/serg2014/go-musthave-diploma/internal/app/metrics"
 	"github.com/serg2014/go-musthave-diploma/internal/app/models"
 	"github.com/serg2014/go-musthave-diploma/internal/app/storage"
+	"github.com/serg2014/go-musthave-diploma/internal/app/tracing"
 	"github.com/serg2014/go-musthave-diploma/internal/config"
 	"github.com/serg2014/go-musthave-diploma/internal/logger"
 	"go.uber.org/zap"
 )
 
-const ChanLimit = 100
-
 func generateWho(port uint16) string {
 	var b strings.Builder
 	fmt.Fprintf(&b, "%x%x", time.Now().Unix(), port)
@@ -31,28 +35,117 @@ func generateWho(port uint16) string {
 	return b.String()
 }
 
+// сколько начислений сжигаем в одной транзакции
+const pointsExpireBatch = 1000
+
+// сколько просроченных резервов снимаем в одной транзакции
+const holdsReleaseBatch = 100
+
 type App struct {
 	config  *config.Config
 	router  *chi.Mux
 	store   storage.Storager
+	auth    *auth.Auth
+	admins  auth.Admins
+	health  *health
+	events  *eventHub
 	reqChan chan *models.ProcessingOrderItem
 	resChan chan *models.AccrualOrderItem
-	who     string
+	// воркер освободился, можно забрать еще заказов
+	wake chan struct{}
+	// результаты заказов сохранены, в outbox могли появиться уведомления
+	webhookWake   chan struct{}
+	webhookClient *http.Client
+	who           string
+	accrual       accrual.Client
 }
 
-func NewApp(cnf *config.Config) (*App, error) {
+func newStorage(cnf *config.Config) (storage.Storager, error) {
+	if cnf.StorageType == config.StorageMemory {
+		logger.Log.Info("Use memory storage")
+		return storage.NewMemStorage(), nil
+	}
 	s, err := storage.NewStorage(context.Background(), cnf.DatabaseDSN)
 	if err != nil {
 		return nil, fmt.Errorf("filed to create NewStorage: %w", err)
 	}
+	return s, nil
+}
+
+func newAuth(cnf *config.Config, s storage.Storager) (*auth.Auth, error) {
+	keys := make([]auth.Key, 0, len(cnf.AuthKeys))
+	for _, k := range cnf.AuthKeys {
+		keys = append(keys, auth.Key{ID: k.ID, Secret: []byte(k.Secret)})
+	}
+	if len(keys) == 0 {
+		// без ключей в конфиге cookie перестанут быть валидными после рестарта
+		logger.Log.Warn("no cookie keys in config, generate random key")
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("failed generate cookie key: %w", err)
+		}
+		keys = append(keys, auth.Key{ID: "auto", Secret: secret})
+	}
+	if cnf.PasswordSecret == config.DefaultPasswordSecret {
+		logger.Log.Warn("default legacy password secret in use, set AUTH_PASSWORD_SECRET")
+	}
+
+	a, err := auth.New(keys, cnf.CookieKeyID, []byte(cnf.PasswordSecret), s, cnf.AccessTokenTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create auth: %w", err)
+	}
+	if cnf.LegacyCookieSecret != "" {
+		if cnf.LegacyCookieSecret == config.DefaultLegacyCookieSecret {
+			logger.Log.Warn("default legacy cookie secret in use, set AUTH_LEGACY_COOKIE_SECRET or empty it when old cookies are gone")
+		}
+		a.EnableLegacyCookie([]byte(cnf.LegacyCookieSecret), cnf.SessionTTL)
+	}
+	return a, nil
+}
+
+func newAdmins(cnf *config.Config) auth.Admins {
+	if len(cnf.Admins) == 0 && len(cnf.Merchants) == 0 {
+		logger.Log.Warn("no admin tokens in config, admin api is disabled")
+	}
+	admins := make(auth.Admins, len(cnf.Admins)+len(cnf.Merchants))
+	for _, adm := range cnf.Admins {
+		admins[adm.ID] = auth.Admin{Token: []byte(adm.Secret), Role: auth.RoleAdmin}
+	}
+	for _, m := range cnf.Merchants {
+		admins[m.ID] = auth.Admin{Token: []byte(m.Secret), Role: auth.RoleMerchant}
+	}
+	return admins
+}
+
+func NewApp(cnf *config.Config, accrualClient accrual.Client) (*App, error) {
+	s, err := newStorage(cnf)
+	if err != nil {
+		return nil, err
+	}
+	s = metrics.NewStorage(tracing.NewStorage(s))
+	if err := metrics.RegisterQueue(s); err != nil {
+		return nil, fmt.Errorf("failed register queue metrics: %w", err)
+	}
+	au, err := newAuth(cnf, s)
+	if err != nil {
+		return nil, err
+	}
 	app := &App{
 		config: cnf,
 		router: chi.NewRouter(),
 		store:  s,
-		// TODO должен быть согласован с лимитом в update
-		reqChan: make(chan *models.ProcessingOrderItem, ChanLimit),
-		resChan: make(chan *models.AccrualOrderItem, ChanLimit),
-		who:     generateWho(cnf.Port),
+		auth:   au,
+		admins: newAdmins(cnf),
+		health: newHealth(),
+		events: newEventHub(),
+		// в очереди не больше заказов, чем воркеров: остальные ждут в хранилище
+		reqChan:       make(chan *models.ProcessingOrderItem, cnf.Workers),
+		resChan:       make(chan *models.AccrualOrderItem, cnf.FlushBatchSize),
+		wake:          make(chan struct{}, 1),
+		webhookWake:   make(chan struct{}, 1),
+		webhookClient: newWebhookClient(),
+		who:           generateWho(cnf.Port),
+		accrual:       accrualClient,
 	}
 	app.setRoute()
 	logger.Log.Debug("app create", zap.String("who", app.who))
@@ -100,61 +193,117 @@ func (a *App) CleanupAfterCrash(ctx context.Context, t time.Duration) error {
 	return err
 }
 
+// ExpirePoints списывает сгоревшие баллы пачками, пока они есть
+func (a *App) ExpirePoints(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		n, err := a.store.ExpirePoints(ctx, pointsExpireBatch)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if n < pointsExpireBatch {
+			return total, nil
+		}
+	}
+}
+
+// ReleaseStaleHolds снимает просроченные резервы пачками, пока они есть
+func (a *App) ReleaseStaleHolds(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		n, err := a.store.ReleaseStaleHolds(ctx, holdsReleaseBatch)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if n < holdsReleaseBatch {
+			return total, nil
+		}
+	}
+}
+
+// CleanupIdempotency удаляет просроченные ключи идемпотентности
+func (a *App) CleanupIdempotency(ctx context.Context) error {
+	return a.store.DeleteExpiredIdempotency(ctx)
+}
+
+// ProcessOrders забирает заказы из orders_for_process и раздает воркерам.
+// Новые заказы берем, как только в очереди освобождается место,
+// результаты пишет в хранилище flusher небольшими пачками
 func (a *App) ProcessOrders(ctx context.Context) {
-	// TODO количество воркеров в конфиг
-	for i := range 10 {
-		go a.worker(ctx, i)
+	metrics.SetWorkers(a.config.Workers)
+	var workers sync.WaitGroup
+	for i := range a.config.Workers {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			a.worker(ctx, i)
+		}()
 	}
 
-	cleanup := func() {
+	flushed := make(chan struct{})
+	go func() {
+		defer close(flushed)
+		a.flusher()
+	}()
+
+	defer func() {
+		// воркеры доделывают текущие заказы, flusher сохраняет результаты,
+		// и только потом отпускаем заказы, которые так и остались в очереди
+		workers.Wait()
+		close(a.resChan)
+		<-flushed
 		err := a.store.CleanOrdersForProcess(context.Background(), a.who)
 		if err != nil {
 			logger.Log.Error("failed CleanOrdersForProcess", zap.Error(err))
 		}
+	}()
+
+	// новые заказы забираем сразу по уведомлению, тикер остается на случай
+	// отложенных попыток и потерянных уведомлений
+	newOrders, err := a.store.ListenNewOrders(ctx)
+	if err != nil {
+		logger.Log.Error("failed ListenNewOrders, use only ticker", zap.Error(err))
 	}
-	defer cleanup()
 
-	// TODO в конфиг
-	ticker := time.NewTicker(1 * time.Second)
+	ticker := time.NewTicker(a.config.PollInterval)
+	defer ticker.Stop()
+	// в прошлый раз забрали столько, сколько влезло. значит есть еще
+	backlog := true
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			cleanup()
-			data, err := a.store.GetOrdersForProcess(ctx, a.who, ChanLimit)
-			if err != nil {
-				logger.Log.Error("failed GetOrdersForProcess", zap.Error(err))
-				break
+			a.health.loop()
+		case _, ok := <-newOrders:
+			if !ok {
+				newOrders = nil
+				continue
 			}
-			if len(data) != 0 {
-				for i := range data {
-					// send orderid and userid
-					a.reqChan <- &data[i]
-				}
-
-				accrual := make([]*models.AccrualOrderItem, 0, len(data))
-				for range data {
-					select {
-					case <-ctx.Done():
-						return
-					case itemPtr := <-a.resChan:
-						if itemPtr.Error != nil {
-							logger.Log.Debug(
-								"failed get Accrual",
-								zap.Error(itemPtr.Error),
-								zap.String("orderID", itemPtr.OrderID),
-							)
-						} else {
-							accrual = append(accrual, itemPtr)
-						}
-					}
-				}
-				err := a.store.UpdateOrders(ctx, accrual, a.who)
-				if err != nil {
-					logger.Log.Error("failed UpdateOrders", zap.Error(err))
-				}
+		case <-a.wake:
+			if !backlog {
+				continue
 			}
 		}
+
+		free := cap(a.reqChan) - len(a.reqChan)
+		if free <= 0 {
+			// воркеры заняты, но обработка идет
+			a.health.tick()
+			continue
+		}
+		data, err := a.store.GetOrdersForProcess(ctx, a.who, uint(free))
+		if err != nil {
+			logger.Log.Error("failed GetOrdersForProcess", zap.Error(err))
+			continue
+		}
+		a.health.tick()
+		backlog = len(data) == free
+		for i := range data {
+			// место в канале есть: кладем только мы, а воркеры только забирают
+			a.reqChan <- &data[i]
+		}
 	}
 }
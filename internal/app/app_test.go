@@ -0,0 +1,135 @@
+package app
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/serg2014/go-musthave-diploma/internal/app/accrual"
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+	"github.com/serg2014/go-musthave-diploma/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAccrual отвечает заранее заданными результатами, остальные заказы не зарегистрированы
+type fakeAccrual struct {
+	mu      sync.Mutex
+	results map[models.OrderID]models.AccrualOrderItem
+	errs    map[models.OrderID]error
+}
+
+func newFakeAccrual() *fakeAccrual {
+	return &fakeAccrual{
+		results: make(map[models.OrderID]models.AccrualOrderItem),
+		errs:    make(map[models.OrderID]error),
+	}
+}
+
+func (f *fakeAccrual) set(orderID models.OrderID, status models.AccrualOrderStatus, sum models.Money) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.results[orderID] = models.AccrualOrderItem{OrderID: orderID, Status: status, Accrual: &sum}
+}
+
+func (f *fakeAccrual) GetAccrual(ctx context.Context, orderID models.OrderID) (*models.AccrualOrderItem, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err, ok := f.errs[orderID]; ok {
+		return nil, err
+	}
+	item, ok := f.results[orderID]
+	if !ok {
+		return nil, accrual.ErrHTTPNoContent
+	}
+	return &item, nil
+}
+
+// newTestApp приложение на хранилище в памяти
+func newTestApp(t *testing.T, client accrual.Client) *App {
+	t.Helper()
+	cnf := &config.Config{
+		Address:            "127.0.0.1:0",
+		StorageType:        config.StorageMemory,
+		PasswordSecret:     "somesecret",
+		LegacyCookieSecret: config.DefaultLegacyCookieSecret,
+		SessionTTL:         time.Hour,
+		AccessTokenTTL:     time.Minute,
+		RetryBaseDelay:     time.Second,
+		RetryMaxDelay:      time.Minute,
+		OrderMaxAttempts:   3,
+		OrderMaxAge:        time.Hour,
+		Workers:            2,
+		PollInterval:       10 * time.Millisecond,
+		FlushBatchSize:     10,
+		FlushInterval:      10 * time.Millisecond,
+	}
+	a, err := NewApp(cnf, client)
+	require.NoError(t, err)
+	return a
+}
+
+// testClient пользователь приложения со своими cookie
+type testClient struct {
+	t      *testing.T
+	server *httptest.Server
+	client *http.Client
+}
+
+func newTestClient(t *testing.T, server *httptest.Server) *testClient {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	return &testClient{t: t, server: server, client: &http.Client{Jar: jar}}
+}
+
+// do возвращает статус и тело ответа
+func (c *testClient) do(method, path, contentType, body string) (int, string) {
+	c.t.Helper()
+	req, err := http.NewRequest(method, c.server.URL+path, strings.NewReader(body))
+	require.NoError(c.t, err)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := c.client.Do(req)
+	require.NoError(c.t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(c.t, err)
+	return resp.StatusCode, string(data)
+}
+
+func (c *testClient) register(login string) {
+	c.t.Helper()
+	status, body := c.do(http.MethodPost, "/api/user/register", "application/json",
+		`{"login":"`+login+`","password":"secret"}`)
+	require.Equal(c.t, http.StatusOK, status, body)
+}
+
+// testUserID пользователь, зарегистрированный через register
+func testUserID(t *testing.T, a *App, login string) models.UserID {
+	t.Helper()
+	user, err := a.store.GetUser(context.Background(), login)
+	require.NoError(t, err)
+	return user.ID
+}
+
+// credit начисляет баллы за новый заказ пользователя, как обработчик заказов
+func credit(t *testing.T, a *App, login string, orderID models.OrderID, sum models.Money) {
+	t.Helper()
+	ctx := context.Background()
+	userID := testUserID(t, a, login)
+	require.NoError(t, a.store.CreateOrder(ctx, orderID, userID))
+	err := a.store.UpdateOrders(ctx, []*models.AccrualOrderItem{{
+		OrderID: orderID,
+		UserID:  userID,
+		Status:  models.AccrualOrderProcessed,
+		Accrual: &sum,
+	}}, a.who)
+	require.NoError(t, err)
+}
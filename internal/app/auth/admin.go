@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"slices"
+
+	usercontext "github.com/serg2014/go-musthave-diploma/internal/app/context"
+	"github.com/serg2014/go-musthave-diploma/internal/app/problem"
+	"github.com/serg2014/go-musthave-diploma/internal/logger"
+	"go.uber.org/zap"
+)
+
+var AdminTokenHeader = "X-Admin-Token"
+
+type Role string
+
+const (
+	RoleAdmin Role = "admin"
+	// магазин: может только отменять списания
+	RoleMerchant Role = "merchant"
+)
+
+type Admin struct {
+	Token []byte
+	Role  Role
+}
+
+// Admins имя администратора или магазина -> токен и роль
+type Admins map[string]Admin
+
+// find ищет администратора по токену. Сравниваем со всеми токенами за постоянное время
+func (a Admins) find(token []byte) (string, Admin, bool) {
+	var (
+		name  string
+		admin Admin
+	)
+	found := false
+	for n, adm := range a {
+		if subtle.ConstantTimeCompare(adm.Token, token) == 1 {
+			name = n
+			admin = adm
+			found = true
+		}
+	}
+	return name, admin, found
+}
+
+// AdminMiddleware пускает только запросы с токеном администратора.
+// Без токенов в конфиге admin api недоступно
+func (a Admins) AdminMiddleware(h http.Handler) http.Handler {
+	return a.RoleMiddleware(RoleAdmin)(h)
+}
+
+// RoleMiddleware пускает запросы с токеном одной из ролей. Чужая роль - 403
+func (a Admins) RoleMiddleware(roles ...Role) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := r.Header.Get(AdminTokenHeader)
+			name, admin, ok := a.find([]byte(token))
+			if token == "" || !ok {
+				problem.Status(w, r, http.StatusUnauthorized, problem.CodeUnauthorized)
+				return
+			}
+			if !slices.Contains(roles, admin.Role) {
+				logger.Log.Info("admin request forbidden", zap.String("admin", name),
+					zap.String("role", string(admin.Role)), zap.String("uri", r.RequestURI))
+				problem.Status(w, r, http.StatusForbidden, problem.CodeForbidden)
+				return
+			}
+			logger.Log.Info("admin request", zap.String("admin", name),
+				zap.String("role", string(admin.Role)), zap.String("uri", r.RequestURI))
+
+			h.ServeHTTP(w, r.WithContext(usercontext.WithAdmin(r.Context(), name)))
+		})
+	}
+}
@@ -1,26 +1,111 @@
 package auth
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	usercontext "github.com/serg2014/go-musthave-diploma/internal/app/context"
 	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+	"github.com/serg2014/go-musthave-diploma/internal/app/problem"
+	"github.com/serg2014/go-musthave-diploma/internal/app/storage"
 	"github.com/serg2014/go-musthave-diploma/internal/logger"
 	"go.uber.org/zap"
 )
 
-var secretForPassword = []byte("somesecret")
-var secretForCookie = []byte("newsomesecret")
 var CookieAuthSep = "."
 var CookieAuthName = "user_id"
 var ErrCookieUserID = fmt.Errorf("no valid cookie %s", CookieAuthName)
+var ErrUnknownKey = errors.New("unknown or retired key")
+var ErrTokenExpired = errors.New("token expired")
+
+// SessionStore часть хранилища, нужная для проверки сессий
+type SessionStore interface {
+	GetActiveSession(ctx context.Context, sessionID models.SessionID) (*models.Session, error)
+	CreateSession(ctx context.Context, userID models.UserID, ttl time.Duration) (*models.Session, error)
+}
+
+// Key ключ подписи cookie. ID попадает в cookie,
+// чтобы при ротации проверять подпись нужным ключом
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+type Auth struct {
+	// ключ которым подписываем новые cookie
+	current Key
+	// все действующие ключи, в том числе current
+	keys map[string][]byte
+	// секрет устаревших HMAC хэшей паролей. пустой - проверка отключена
+	passwordSecret []byte
+	sessions       SessionStore
+	// время жизни access токена
+	accessTTL time.Duration
+	// секрет cookie старого формата userid.signature. пустой - такие cookie не принимаем
+	legacyCookieSecret []byte
+	// время жизни сессии, которую создаем взамен старой cookie
+	sessionTTL time.Duration
+}
+
+// tokenClaims данные из подписанной cookie
+type tokenClaims struct {
+	UserID    models.UserID
+	SessionID models.SessionID
+	ExpiresAt time.Time
+	// cookie старого формата, сессии у нее нет
+	legacy bool
+}
+
+// New keys - действующие ключи, currentKeyID - ключ для подписи новых cookie.
+// Если currentKeyID пустой, используется первый ключ.
+func New(keys []Key, currentKeyID string, passwordSecret []byte, sessions SessionStore, accessTTL time.Duration) (*Auth, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no cookie keys")
+	}
+	a := &Auth{
+		keys:           make(map[string][]byte, len(keys)),
+		passwordSecret: passwordSecret,
+		sessions:       sessions,
+		accessTTL:      accessTTL,
+	}
+	for _, k := range keys {
+		if k.ID == "" || strings.Contains(k.ID, CookieAuthSep) {
+			return nil, fmt.Errorf("bad key id %q", k.ID)
+		}
+		if len(k.Secret) == 0 {
+			return nil, fmt.Errorf("empty secret for key %q", k.ID)
+		}
+		if _, ok := a.keys[k.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id %q", k.ID)
+		}
+		a.keys[k.ID] = k.Secret
+	}
+	if currentKeyID == "" {
+		currentKeyID = keys[0].ID
+	}
+	secret, ok := a.keys[currentKeyID]
+	if !ok {
+		return nil, fmt.Errorf("current key %q not found", currentKeyID)
+	}
+	a.current = Key{ID: currentKeyID, Secret: secret}
+	return a, nil
+}
+
+// EnableLegacyCookie принимать cookie старого формата userid.signature,
+// подписанные secret, и заменять их новой cookie с сессией на sessionTTL
+func (a *Auth) EnableLegacyCookie(secret []byte, sessionTTL time.Duration) {
+	a.legacyCookieSecret = secret
+	a.sessionTTL = sessionTTL
+}
 
 func sign(value, key []byte) string {
 	h := hmac.New(sha256.New, key)
@@ -28,58 +113,162 @@ func sign(value, key []byte) string {
 	return hex.EncodeToString(h.Sum(nil))
 }
 
-func SignPassword(password string) string {
-	return sign([]byte(password), secretForPassword)
+// signPassword устаревший способ хэширования пароля.
+// Оставлен для проверки старых хэшей, см. CheckPassword
+func signPassword(password string, secret []byte) string {
+	return sign([]byte(password), secret)
 }
 
-func CreateAuthCookie(userID models.UserID) *http.Cookie {
-	signature := sign(userID[:], secretForCookie)
-	cookieVal := fmt.Sprintf("%s%s%s", userID.String(), CookieAuthSep, signature)
+// CreateAuthCookie value = userid.sessionid.expires.keyid.signature
+// подпись считается от всего что перед ней
+func (a *Auth) CreateAuthCookie(session *models.Session) *http.Cookie {
+	payload := strings.Join([]string{
+		session.UserID.String(),
+		session.ID.String(),
+		strconv.FormatInt(session.ExpiresAt.Unix(), 10),
+		a.current.ID,
+	}, CookieAuthSep)
+	signature := sign([]byte(payload), a.current.Secret)
+	cookieVal := payload + CookieAuthSep + signature
 
 	cookie := &http.Cookie{
 		Name:     CookieAuthName,
 		Value:    cookieVal,
 		Path:     "/",
+		Expires:  session.ExpiresAt,
 		HttpOnly: true,                    // Доступ только через HTTP, защита от XSS
 		SameSite: http.SameSiteStrictMode, // Защита от CSRF
 	}
 	return cookie
 }
 
+// ClearAuthCookie cookie для удаления авторизации у клиента
+func ClearAuthCookie() *http.Cookie {
+	return &http.Cookie{
+		Name:     CookieAuthName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}
+}
+
 // ====
-func checkToken(token string) (*models.UserID, error) {
+func (a *Auth) checkToken(token string) (*tokenClaims, error) {
 	items := strings.Split(token, CookieAuthSep)
-	if len(items) != 2 {
+	if len(items) == 2 && len(a.legacyCookieSecret) != 0 {
+		return a.checkLegacyToken(items)
+	}
+	if len(items) != 5 {
 		return nil, errors.New("bad token")
 	}
 	userID, err := uuid.Parse(items[0])
 	if err != nil {
 		return nil, fmt.Errorf("bad userid from cookie: %w", err)
 	}
-	if sign(userID[:], secretForCookie) != items[1] {
+	sessionID, err := uuid.Parse(items[1])
+	if err != nil {
+		return nil, fmt.Errorf("bad session id from cookie: %w", err)
+	}
+	expires, err := strconv.ParseInt(items[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad expires from cookie: %w", err)
+	}
+	secret, ok := a.keys[items[3]]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	payload := strings.Join(items[:4], CookieAuthSep)
+	if !hmac.Equal([]byte(sign([]byte(payload), secret)), []byte(items[4])) {
+		return nil, errors.New("bad signature")
+	}
+	claims := &tokenClaims{
+		UserID:    userID,
+		SessionID: sessionID,
+		ExpiresAt: time.Unix(expires, 0),
+	}
+	if !time.Now().Before(claims.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+	return claims, nil
+}
+
+// checkLegacyToken проверяет cookie старого формата userid.signature
+func (a *Auth) checkLegacyToken(items []string) (*tokenClaims, error) {
+	userID, err := uuid.Parse(items[0])
+	if err != nil {
+		return nil, fmt.Errorf("bad userid from cookie: %w", err)
+	}
+	if !hmac.Equal([]byte(sign(userID[:], a.legacyCookieSecret)), []byte(items[1])) {
 		return nil, errors.New("bad signature")
 	}
-	return &userID, nil
+	return &tokenClaims{UserID: userID, legacy: true}, nil
+}
+
+// upgradeLegacyCookie создает сессию для cookie старого формата и ставит новую cookie
+func (a *Auth) upgradeLegacyCookie(w http.ResponseWriter, r *http.Request, userID models.UserID) (*tokenClaims, error) {
+	session, err := a.sessions.CreateSession(r.Context(), userID, a.sessionTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed upgrade legacy cookie: %w", err)
+	}
+	http.SetCookie(w, a.CreateAuthCookie(session))
+	logger.Log.Info("legacy cookie upgraded", zap.String("user_id", userID.String()))
+	return &tokenClaims{UserID: userID, SessionID: session.ID, ExpiresAt: session.ExpiresAt}, nil
 }
 
-func GetUserIDFromCookie(r *http.Request) (*models.UserID, error) {
+// checkSession проверяет что сессия из токена не отозвана и принадлежит пользователю
+func (a *Auth) checkSession(ctx context.Context, claims *tokenClaims) error {
+	session, err := a.sessions.GetActiveSession(ctx, claims.SessionID)
+	if err != nil {
+		return err
+	}
+	if session.UserID != claims.UserID {
+		return storage.ErrSessionNotFound
+	}
+	return nil
+}
+
+func (a *Auth) getClaimsFromCookie(r *http.Request) (*tokenClaims, error) {
 	cookie, err := r.Cookie(CookieAuthName)
 	if err != nil {
 		return nil, ErrCookieUserID
 	}
-	userID, err := checkToken(cookie.Value)
+	claims, err := a.checkToken(cookie.Value)
 	if err != nil {
 		return nil, err
 	}
-	return userID, nil
+	if claims.legacy {
+		return claims, nil
+	}
+	if err := a.checkSession(r.Context(), claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// getClaims берет пользователя из Authorization: Bearer, а если заголовка нет - из cookie
+func (a *Auth) getClaims(r *http.Request) (*tokenClaims, error) {
+	claims, err := a.getClaimsFromBearer(r)
+	if errors.Is(err, ErrNoBearer) {
+		return a.getClaimsFromCookie(r)
+	}
+	return claims, err
+}
+
+func (a *Auth) GetUserIDFromRequest(r *http.Request) (*models.UserID, error) {
+	claims, err := a.getClaims(r)
+	if err != nil {
+		return nil, err
+	}
+	return &claims.UserID, nil
 }
 
 func AuthMiddleware(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		_, err := usercontext.GetUserID(r.Context())
 		if err != nil {
-			code := http.StatusUnauthorized
-			http.Error(w, http.StatusText(code), code)
+			problem.Status(w, r, http.StatusUnauthorized, problem.CodeUnauthorized)
 			return
 		}
 
@@ -88,17 +277,25 @@ func AuthMiddleware(h http.Handler) http.Handler {
 	})
 }
 
-func WithUserMiddleware(h http.Handler) http.Handler {
+func (a *Auth) WithUserMiddleware(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		userID, err := GetUserIDFromCookie(r)
+		claims, err := a.getClaims(r)
+		if err == nil && claims.legacy {
+			claims, err = a.upgradeLegacyCookie(w, r, claims.UserID)
+		}
 		if err != nil {
-			logger.Log.Debug("no user id from cookie", zap.Error(err))
+			if errors.Is(err, ErrCookieUserID) || errors.Is(err, storage.ErrSessionNotFound) || errors.Is(err, ErrTokenExpired) {
+				logger.Log.Debug("no user id from cookie", zap.Error(err))
+			} else {
+				logger.Log.Info("bad auth cookie", zap.Error(err))
+			}
 		}
 		// rew - request with user
 		rwu := r
 		if err == nil {
 			// сохраним в контекст
-			ctx := usercontext.WithUser(r.Context(), userID)
+			ctx := usercontext.WithUser(r.Context(), &claims.UserID)
+			ctx = usercontext.WithSession(ctx, &claims.SessionID)
 			rwu = r.WithContext(ctx)
 		}
 
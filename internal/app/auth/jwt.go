@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+	"github.com/serg2014/go-musthave-diploma/internal/app/storage"
+)
+
+const (
+	tokenTypeAccess  = "access"
+	tokenTypeRefresh = "refresh"
+	bearerPrefix     = "Bearer "
+)
+
+var ErrNoBearer = errors.New("no bearer token")
+var ErrBadTokenType = errors.New("bad token type")
+var ErrInvalidToken = errors.New("invalid token")
+
+type jwtClaims struct {
+	jwt.RegisteredClaims
+	SessionID string `json:"sid"`
+	Type      string `json:"typ"`
+}
+
+func (a *Auth) signJWT(session *models.Session, typ string, expiresAt time.Time) (string, error) {
+	claims := jwtClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   session.UserID.String(),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+		SessionID: session.ID.String(),
+		Type:      typ,
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = a.current.ID
+	signed, err := token.SignedString(a.current.Secret)
+	if err != nil {
+		return "", fmt.Errorf("failed sign jwt: %w", err)
+	}
+	return signed, nil
+}
+
+// CreateTokens access токен живет accessTTL, но не дольше сессии.
+// refresh токен живет столько же сколько сессия
+func (a *Auth) CreateTokens(session *models.Session, refreshToken string) (*models.AuthTokens, error) {
+	now := time.Now()
+	accessExpires := now.Add(a.accessTTL)
+	if session.ExpiresAt.Before(accessExpires) {
+		accessExpires = session.ExpiresAt
+	}
+	access, err := a.signJWT(session, tokenTypeAccess, accessExpires)
+	if err != nil {
+		return nil, err
+	}
+	if refreshToken == "" {
+		refreshToken, err = a.signJWT(session, tokenTypeRefresh, session.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &models.AuthTokens{
+		AccessToken:  access,
+		RefreshToken: refreshToken,
+		TokenType:    strings.TrimSpace(bearerPrefix),
+		ExpiresIn:    int64(accessExpires.Sub(now).Seconds()),
+	}, nil
+}
+
+func (a *Auth) parseJWT(token string, typ string) (*tokenClaims, error) {
+	var claims jwtClaims
+	_, err := jwt.ParseWithClaims(
+		token,
+		&claims,
+		func(t *jwt.Token) (any, error) {
+			kid, _ := t.Header["kid"].(string)
+			secret, ok := a.keys[kid]
+			if !ok {
+				return nil, ErrUnknownKey
+			}
+			return secret, nil
+		},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, ErrTokenExpired
+		}
+		return nil, fmt.Errorf("bad jwt: %w", err)
+	}
+	if claims.Type != typ {
+		return nil, ErrBadTokenType
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("bad userid from jwt: %w", err)
+	}
+	sessionID, err := uuid.Parse(claims.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("bad session id from jwt: %w", err)
+	}
+	return &tokenClaims{
+		UserID:    userID,
+		SessionID: sessionID,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}, nil
+}
+
+func (a *Auth) getClaimsFromBearer(r *http.Request) (*tokenClaims, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, bearerPrefix) {
+		return nil, ErrNoBearer
+	}
+	claims, err := a.parseJWT(strings.TrimPrefix(header, bearerPrefix), tokenTypeAccess)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.checkSession(r.Context(), claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// Refresh выдает новый access токен по refresh токену, если сессия еще активна
+func (a *Auth) Refresh(ctx context.Context, refreshToken string) (*models.AuthTokens, error) {
+	claims, err := a.parseJWT(refreshToken, tokenTypeRefresh)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	if err := a.checkSession(ctx, claims); err != nil {
+		if errors.Is(err, storage.ErrSessionNotFound) {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+		}
+		return nil, err
+	}
+	// срок жизни refresh токена совпадает со сроком сессии
+	session := &models.Session{
+		ID:        claims.SessionID,
+		UserID:    claims.UserID,
+		ExpiresAt: claims.ExpiresAt,
+	}
+	return a.CreateTokens(session, refreshToken)
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// параметры argon2id по рекомендациям OWASP
+const (
+	argon2Time    uint32 = 2
+	argon2Memory  uint32 = 19 * 1024
+	argon2Threads uint8  = 1
+	argon2KeyLen  uint32 = 32
+	argon2SaltLen        = 16
+)
+
+const argon2Prefix = "$argon2id$"
+
+var ErrBadPasswordHash = errors.New("bad password hash")
+
+type argon2Params struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+}
+
+var currentArgon2Params = argon2Params{
+	time:    argon2Time,
+	memory:  argon2Memory,
+	threads: argon2Threads,
+}
+
+// хэш для сравнения когда пользователь не найден,
+// чтобы время ответа не выдавало существование логина
+var dummyPasswordHash, _ = HashPassword("dummy password")
+
+// HashPassword возвращает хэш в формате PHC:
+// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed generate salt: %w", err)
+	}
+	p := currentArgon2Params
+	key := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, argon2KeyLen)
+	return fmt.Sprintf(
+		"%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2Prefix,
+		argon2.Version,
+		p.memory, p.time, p.threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func parseArgon2Hash(encoded string) (*argon2Params, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return nil, nil, nil, ErrBadPasswordHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, nil, nil, ErrBadPasswordHash
+	}
+	var p argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return nil, nil, nil, ErrBadPasswordHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, ErrBadPasswordHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, ErrBadPasswordHash
+	}
+	return &p, salt, key, nil
+}
+
+// CheckPassword сверяет пароль с хэшем из бд.
+// needRehash=true если хэш устаревшего формата (HMAC) или с другими параметрами
+// и его стоит пересчитать через HashPassword.
+func (a *Auth) CheckPassword(password, encoded string) (ok bool, needRehash bool) {
+	if !strings.HasPrefix(encoded, argon2Prefix) {
+		// legacy: HMAC-SHA256 без соли
+		if len(a.passwordSecret) == 0 {
+			return false, false
+		}
+		ok = hmac.Equal([]byte(signPassword(password, a.passwordSecret)), []byte(encoded))
+		return ok, ok
+	}
+
+	p, salt, key, err := parseArgon2Hash(encoded)
+	if err != nil {
+		return false, false
+	}
+	other := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return false, false
+	}
+	return true, *p != currentArgon2Params || len(key) != int(argon2KeyLen)
+}
+
+// SimulateCheckPassword тратит столько же времени сколько CheckPassword
+func (a *Auth) SimulateCheckPassword(password string) {
+	a.CheckPassword(password, dummyPasswordHash)
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPassword(t *testing.T) {
+	legacySecret := []byte("somesecret")
+	a, err := New([]Key{{ID: "k1", Secret: []byte("cookie secret")}}, "", legacySecret, nil, time.Minute)
+	require.NoError(t, err)
+	// без секрета устаревшие хэши не проверяются
+	noLegacy, err := New([]Key{{ID: "k1", Secret: []byte("cookie secret")}}, "", nil, nil, time.Minute)
+	require.NoError(t, err)
+
+	hash, err := HashPassword("secret")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(hash, argon2Prefix))
+	other, err := HashPassword("secret")
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, other, "salt must differ")
+
+	// слабые параметры прошлых версий
+	saved := currentArgon2Params
+	currentArgon2Params = argon2Params{time: 1, memory: 8 * 1024, threads: 1}
+	weak, err := HashPassword("secret")
+	currentArgon2Params = saved
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		auth       *Auth
+		password   string
+		hash       string
+		ok         bool
+		needRehash bool
+	}{
+		{"argon2id", a, "secret", hash, true, false},
+		{"argon2id wrong password", a, "other", hash, false, false},
+		{"old params", a, "secret", weak, true, true},
+		{"legacy", a, "secret", signPassword("secret", legacySecret), true, true},
+		{"legacy wrong password", a, "other", signPassword("secret", legacySecret), false, false},
+		{"legacy disabled", noLegacy, "secret", signPassword("secret", legacySecret), false, false},
+		{"broken", a, "secret", "$argon2id$v=19$m=1,t=1$salt$key", false, false},
+		{"empty", a, "secret", "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, needRehash := tt.auth.CheckPassword(tt.password, tt.hash)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.needRehash, needRehash)
+		})
+	}
+}
@@ -24,3 +24,39 @@ func GetUserID(ctx context.Context) (*models.UserID, error) {
 	}
 	return userID, nil
 }
+
+type sessionCtxKeyType string
+
+var ErrSessionIDFromContext = fmt.Errorf("no session id in context")
+
+const sessionCtxKey sessionCtxKeyType = "sessionID"
+
+func WithSession(ctx context.Context, sessionID *models.SessionID) context.Context {
+	return context.WithValue(ctx, sessionCtxKey, sessionID)
+}
+
+func GetSessionID(ctx context.Context) (*models.SessionID, error) {
+	sessionID, ok := ctx.Value(sessionCtxKey).(*models.SessionID)
+	if !ok {
+		return nil, ErrSessionIDFromContext
+	}
+	return sessionID, nil
+}
+
+type adminCtxKeyType string
+
+var ErrAdminFromContext = fmt.Errorf("no admin in context")
+
+const adminCtxKey adminCtxKeyType = "admin"
+
+func WithAdmin(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, adminCtxKey, name)
+}
+
+func GetAdmin(ctx context.Context) (string, error) {
+	name, ok := ctx.Value(adminCtxKey).(string)
+	if !ok {
+		return "", ErrAdminFromContext
+	}
+	return name, nil
+}
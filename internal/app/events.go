@@ -0,0 +1,197 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	usercontext "github.com/serg2014/go-musthave-diploma/internal/app/context"
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+	"github.com/serg2014/go-musthave-diploma/internal/app/problem"
+	"github.com/serg2014/go-musthave-diploma/internal/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// сколько событий читаем из журнала за раз
+	eventsPageSize = 100
+	// через сколько браузеру переподключаться после обрыва, мс
+	eventsRetry = 3000
+)
+
+// eventHub подписки открытых SSE соединений внутри процесса
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[models.UserID]map[chan struct{}]struct{}
+	// закрывается при остановке сервиса, соединения завершаются
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{
+		subs: make(map[models.UserID]map[chan struct{}]struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+func (h *eventHub) subscribe(userID models.UserID) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	h.mu.Lock()
+	if h.subs[userID] == nil {
+		h.subs[userID] = make(map[chan struct{}]struct{})
+	}
+	h.subs[userID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.subs[userID], ch)
+		if len(h.subs[userID]) == 0 {
+			delete(h.subs, userID)
+		}
+	}
+}
+
+// notify будит соединения пользователя, uuid.Nil - всех
+func (h *eventHub) notify(userID models.UserID) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, subs := range h.subs {
+		if userID != uuid.Nil && id != userID {
+			continue
+		}
+		for ch := range subs {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func (h *eventHub) close() {
+	h.closeOnce.Do(func() { close(h.done) })
+}
+
+// DispatchUserEvents передает уведомления хранилища о новых событиях открытым соединениям
+func (a *App) DispatchUserEvents(ctx context.Context) {
+	ch, err := a.store.ListenUserEvents(ctx)
+	if err != nil {
+		// соединения все равно проверяют журнал на каждом heartbeat
+		logger.Log.Error("failed ListenUserEvents, use only heartbeat", zap.Error(err))
+		return
+	}
+	for userID := range ch {
+		a.events.notify(userID)
+	}
+}
+
+// CleanupUserEvents удаляет события старше EventsTTL
+func (a *App) CleanupUserEvents(ctx context.Context) error {
+	return a.store.DeleteExpiredUserEvents(ctx, a.config.EventsTTL)
+}
+
+// lastEventID позиция, с которой продолжить поток. Браузер после обрыва
+// присылает заголовок Last-Event-ID, для первого подключения можно передать параметр
+func lastEventID(r *http.Request) (int64, bool, error) {
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		v = r.URL.Query().Get("last_event_id")
+	}
+	if v == "" {
+		return 0, false, nil
+	}
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || id < 0 {
+		return 0, false, errors.New("bad last event id")
+	}
+	return id, true, nil
+}
+
+// userEvents поток событий пользователя в формате text/event-stream.
+// Без Last-Event-ID отдает только новые события
+func (a *App) userEvents() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := usercontext.GetUserID(r.Context())
+		if err != nil {
+			problem.Status(w, r, http.StatusUnauthorized, problem.CodeUnauthorized)
+			return
+		}
+		lastID, resume, err := lastEventID(r)
+		if err != nil {
+			problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeInvalidQuery, err.Error()))
+			return
+		}
+
+		// подписываемся до чтения журнала, чтобы не потерять события между ними
+		wake, unsubscribe := a.events.subscribe(*userID)
+		defer unsubscribe()
+
+		if !resume {
+			lastID, err = a.store.LastUserEventID(r.Context(), *userID)
+			if err != nil {
+				problem.Error(w, r, err, "failed LastUserEventID")
+				return
+			}
+		}
+
+		rc := http.NewResponseController(w)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		// nginx не должен буферизовать поток
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "retry: %d\n\n", eventsRetry)
+		if err := rc.Flush(); err != nil {
+			logger.Log.Error("streaming is not supported", zap.Error(err))
+			return
+		}
+
+		heartbeat := time.NewTicker(a.config.EventsHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			events, err := a.store.UserEvents(r.Context(), *userID, lastID, eventsPageSize)
+			if err != nil {
+				if r.Context().Err() == nil {
+					// клиент переподключится с Last-Event-ID
+					logger.Log.Error("failed UserEvents", zap.Error(err), zap.String("user_id", userID.String()))
+				}
+				return
+			}
+			for _, event := range events {
+				fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data)
+				lastID = event.ID
+			}
+			if len(events) > 0 {
+				if err := rc.Flush(); err != nil {
+					return
+				}
+			}
+			if len(events) == eventsPageSize {
+				continue
+			}
+
+			select {
+			case <-r.Context().Done():
+				return
+			case <-a.events.done:
+				return
+			case <-wake:
+			case <-heartbeat.C:
+				// держим соединение открытым через прокси. Заодно перечитываем журнал:
+				// уведомление могло потеряться
+				fmt.Fprint(w, ": ping\n\n")
+				if err := rc.Flush(); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"slices"
 	"strings"
+
+	"github.com/serg2014/go-musthave-diploma/internal/app/problem"
 )
 
 func gzipMiddleware(h http.Handler) http.Handler {
@@ -25,7 +27,7 @@ func gzipMiddleware(h http.Handler) http.Handler {
 			// оборачиваем тело запроса в io.Reader с поддержкой декомпрессии
 			cr, err := newCompressReader(r.Body)
 			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
+				problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeBadRequest, "bad gzip body"))
 				return
 			}
 			defer cr.Close()
@@ -64,6 +66,15 @@ func (w *compressWriter) WriteHeader(statusCode int) {
 	w.w.WriteHeader(statusCode)
 }
 
+// FlushError для потоковых ответов (SSE): отдаем клиенту все, что уже сжато.
+// Вызывается через http.ResponseController
+func (w *compressWriter) FlushError() error {
+	if err := w.zw.Flush(); err != nil {
+		return err
+	}
+	return http.NewResponseController(w.w).Flush()
+}
+
 func (w *compressWriter) Close() error {
 	return w.zw.Close()
 }
@@ -1,44 +1,80 @@
 package app
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
+	"strconv"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/serg2014/go-musthave-diploma/internal/app/auth"
 	usercontext "github.com/serg2014/go-musthave-diploma/internal/app/context"
+	"github.com/serg2014/go-musthave-diploma/internal/app/metrics"
 	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+	"github.com/serg2014/go-musthave-diploma/internal/app/problem"
 	"github.com/serg2014/go-musthave-diploma/internal/app/storage"
+	"github.com/serg2014/go-musthave-diploma/internal/app/tracing"
 	"github.com/serg2014/go-musthave-diploma/internal/logger"
 	"go.uber.org/zap"
 )
 
 func (a *App) setRoute() {
 	r := a.GetRouter()
-	r.Use(auth.WithUserMiddleware)
+	r.Use(middleware.RequestID)
+	r.Use(requestIDHeader)
+	r.Use(metrics.WithMetrics)
+	r.Use(tracing.WithTracing)
+	r.Use(a.auth.WithUserMiddleware)
 	r.Use(logger.WithLogging)
 	r.Use(gzipMiddleware)
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		problem.Status(w, r, http.StatusNotFound, problem.CodeNotFound)
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		problem.Status(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed)
+	})
 	r.Post("/api/user/register", a.registerUser())
 	r.Post("/api/user/login", a.authUser())
+	r.Post("/api/user/logout", a.logout())
+	r.Post("/api/user/token/refresh", a.refreshToken())
+	a.setAdminRoute(r)
+	r.Handle("/metrics", metrics.Handler())
+	r.Get("/healthz", a.healthz())
+	r.Get("/readyz", a.readyz())
 
 	r.Group(func(r chi.Router) {
 		r.Use(auth.AuthMiddleware)
 		//r.Use(middleware.Recoverer)
 
 		r.Route("/api/user", func(r chi.Router) {
-			r.Post("/orders", a.createOrder())
+			r.With(a.idempotent).Post("/orders", a.createOrder())
 			r.Get("/orders", a.GetOrders())
 			r.Get("/balance", a.Balance())
-			r.Post("/balance/withdraw", a.Withdraw())
+			r.With(a.idempotent).Post("/balance/withdraw", a.Withdraw())
+			r.With(a.idempotent).Post("/balance/hold", a.Hold())
 			r.Get("/withdrawals", a.Withdrawals())
+			r.With(requireIdempotencyKey, a.idempotent).Post("/balance/transfer", a.Transfer())
+			r.Get("/transfers", a.Transfers())
+			r.Get("/adjustments", a.listAdjustments(false))
+			r.Post("/logout/all", a.logoutAll())
+			r.Put("/webhook", a.setWebhook())
+			r.Get("/webhook", a.getWebhook())
+			r.Delete("/webhook", a.deleteWebhook())
+			r.Get("/webhook/deliveries", a.webhookDeliveries())
+			r.Get("/events", a.userEvents())
 		})
 	})
 }
 
-func simpleError(w http.ResponseWriter, code int) {
-	http.Error(w, http.StatusText(code), code)
+// requestIDHeader отдает request id клиенту, он же попадает в тело ошибок и в логи
+func requestIDHeader(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(middleware.RequestIDHeader, middleware.GetReqID(r.Context()))
+		h.ServeHTTP(w, r)
+	})
 }
 
 func (a *App) registerUser() http.HandlerFunc {
@@ -47,24 +83,25 @@ func (a *App) registerUser() http.HandlerFunc {
 		dec := json.NewDecoder(r.Body)
 		if err := dec.Decode(&req); err != nil {
 			logger.Log.Debug("cannot decode request JSON body", zap.Error(err))
-			http.Error(w, "bad json", http.StatusBadRequest)
+			problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeBadJSON, "bad json"))
 			return
 		}
 		if req.Login == "" || req.Password == "" {
-			http.Error(w, "empty login or password", http.StatusBadRequest)
+			problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeEmptyCredentials, "empty login or password"))
+			return
+		}
+		hashPassword, err := auth.HashPassword(req.Password)
+		if err != nil {
+			logger.Log.Error("failed HashPassword", zap.Error(err))
+			problem.Status(w, r, http.StatusInternalServerError, problem.CodeInternal)
 			return
 		}
-		hashPassword := auth.SignPassword(req.Password)
 		userIDPtr, err := a.store.CreateUser(r.Context(), req.Login, hashPassword)
 		if err != nil {
-			if errors.Is(err, storage.ErrUserExists) {
-				http.Error(w, "user exists", http.StatusConflict)
-				return
-			}
-			simpleError(w, http.StatusInternalServerError)
+			problem.Error(w, r, err, "failed CreateUser")
 			return
 		}
-		setAuthCookie(*userIDPtr, w)
+		a.startSession(w, r, *userIDPtr)
 	}
 }
 
@@ -74,64 +111,170 @@ func (a *App) authUser() http.HandlerFunc {
 		dec := json.NewDecoder(r.Body)
 		if err := dec.Decode(&req); err != nil {
 			logger.Log.Debug("cannot decode request JSON body", zap.Error(err))
-			http.Error(w, "bad json", http.StatusBadRequest)
+			problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeBadJSON, "bad json"))
 			return
 		}
 		if req.Login == "" || req.Password == "" {
-			http.Error(w, "empty login or password", http.StatusBadRequest)
+			problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeEmptyCredentials, "empty login or password"))
 			return
 		}
-		hashPassword := auth.SignPassword(req.Password)
-		userIDPtr, err := a.store.GetUser(r.Context(), req.Login, hashPassword)
+		user, err := a.store.GetUser(r.Context(), req.Login)
 		if err != nil {
 			if errors.Is(err, storage.ErrUserOrPassword) {
-				simpleError(w, http.StatusUnauthorized)
-				return
+				a.auth.SimulateCheckPassword(req.Password)
 			}
-			simpleError(w, http.StatusInternalServerError)
+			problem.Error(w, r, err, "failed GetUser")
 			return
 		}
-		setAuthCookie(*userIDPtr, w)
+		ok, needRehash := a.auth.CheckPassword(req.Password, user.Hash)
+		if !ok {
+			problem.Error(w, r, storage.ErrUserOrPassword, "")
+			return
+		}
+		if needRehash {
+			a.rehashPassword(r.Context(), user.ID, req.Password)
+		}
+		a.startSession(w, r, user.ID)
 	}
 }
 
-func setAuthCookie(userID models.UserID, w http.ResponseWriter) {
-	cookie := auth.CreateAuthCookie(userID)
+// rehashPassword обновляет хэш пароля до текущего формата.
+// Ошибка не мешает входу пользователя, поэтому только логируем
+func (a *App) rehashPassword(ctx context.Context, userID models.UserID, password string) {
+	hashPassword, err := auth.HashPassword(password)
+	if err != nil {
+		logger.Log.Error("failed HashPassword", zap.Error(err))
+		return
+	}
+	err = a.store.UpdateUserHash(ctx, userID, hashPassword)
+	if err != nil {
+		logger.Log.Error("failed UpdateUserHash", zap.Error(err), zap.String("user_id", userID.String()))
+		return
+	}
+	logger.Log.Info("password hash upgraded", zap.String("user_id", userID.String()))
+}
+
+// startSession создает сессию и ставит cookie.
+// С параметром ?token=true дополнительно отдает JWT токены в теле ответа
+func (a *App) startSession(w http.ResponseWriter, r *http.Request, userID models.UserID) {
+	session, err := a.store.CreateSession(r.Context(), userID, a.config.SessionTTL)
+	if err != nil {
+		logger.Log.Error("failed CreateSession", zap.Error(err), zap.String("user_id", userID.String()))
+		problem.Status(w, r, http.StatusInternalServerError, problem.CodeInternal)
+		return
+	}
+	cookie := a.auth.CreateAuthCookie(session)
 	http.SetCookie(w, cookie)
+
+	withToken, _ := strconv.ParseBool(r.URL.Query().Get("token"))
+	if !withToken {
+		return
+	}
+	tokens, err := a.auth.CreateTokens(session, "")
+	if err != nil {
+		logger.Log.Error("failed CreateTokens", zap.Error(err))
+		problem.Status(w, r, http.StatusInternalServerError, problem.CodeInternal)
+		return
+	}
+	writeJSON(w, tokens)
+}
+
+func (a *App) refreshToken() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req models.RefreshRequest
+		dec := json.NewDecoder(r.Body)
+		if err := dec.Decode(&req); err != nil || req.RefreshToken == "" {
+			logger.Log.Debug("cannot decode request JSON body", zap.Error(err))
+			problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeBadJSON, "bad json"))
+			return
+		}
+		tokens, err := a.auth.Refresh(r.Context(), req.RefreshToken)
+		if err != nil {
+			if errors.Is(err, auth.ErrInvalidToken) {
+				logger.Log.Debug("failed refresh token", zap.Error(err))
+				problem.Status(w, r, http.StatusUnauthorized, problem.CodeInvalidRefreshToken)
+				return
+			}
+			logger.Log.Error("failed refresh token", zap.Error(err))
+			problem.Status(w, r, http.StatusInternalServerError, problem.CodeInternal)
+			return
+		}
+		writeJSON(w, tokens)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, data any) {
+	// порядок важен
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(data); err != nil {
+		logger.Log.Error("error encoding response", zap.Error(err))
+	}
+}
+
+// logout завершает текущую сессию. Без сессии просто удаляем cookie
+func (a *App) logout() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sessionID, err := usercontext.GetSessionID(r.Context())
+		if err == nil {
+			err = a.store.RevokeSession(r.Context(), *sessionID)
+			if err != nil {
+				logger.Log.Error("failed RevokeSession", zap.Error(err))
+				problem.Status(w, r, http.StatusInternalServerError, problem.CodeInternal)
+				return
+			}
+		}
+		http.SetCookie(w, auth.ClearAuthCookie())
+	}
+}
+
+// logoutAll завершает все сессии пользователя на всех устройствах
+func (a *App) logoutAll() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := usercontext.GetUserID(r.Context())
+		if err != nil {
+			problem.Status(w, r, http.StatusUnauthorized, problem.CodeUnauthorized)
+			return
+		}
+		err = a.store.RevokeUserSessions(r.Context(), *userID)
+		if err != nil {
+			logger.Log.Error("failed RevokeUserSessions", zap.Error(err), zap.String("user_id", userID.String()))
+			problem.Status(w, r, http.StatusInternalServerError, problem.CodeInternal)
+			return
+		}
+		http.SetCookie(w, auth.ClearAuthCookie())
+	}
 }
 
 func (a *App) createOrder() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID, err := usercontext.GetUserID(r.Context())
 		if err != nil {
-			simpleError(w, http.StatusUnauthorized)
+			problem.Status(w, r, http.StatusUnauthorized, problem.CodeUnauthorized)
 			return
 		}
 
 		order, err := io.ReadAll(r.Body)
 		orderID := string(order)
 		if err != nil || len(orderID) == 0 {
-			simpleError(w, http.StatusBadRequest)
+			problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeBadRequest, "order number is required"))
 			return
 		}
 		err = checkLuhn(orderID)
 		if err != nil {
-			simpleError(w, http.StatusUnprocessableEntity)
+			problem.Write(w, r, problem.New(http.StatusUnprocessableEntity, problem.CodeInvalidOrderNumber, err.Error()))
 			return
 		}
 
 		err = a.store.CreateOrder(r.Context(), orderID, *userID)
 		if err != nil {
-			if errors.Is(err, storage.ErrOrderAnotherUser) {
-				simpleError(w, http.StatusConflict)
-				return
-			}
 			if errors.Is(err, storage.ErrOrderExists) {
-				simpleError(w, http.StatusOK)
+				// не ошибка: заказ уже загружен этим пользователем
+				w.WriteHeader(http.StatusOK)
 				return
 			}
-			logger.Log.Error("failed CreateOrder", zap.Error(err))
-			simpleError(w, http.StatusInternalServerError)
+			problem.Error(w, r, err, "failed CreateOrder")
 			return
 		}
 		w.WriteHeader(http.StatusAccepted)
@@ -143,17 +286,25 @@ func (a *App) GetOrders() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID, err := usercontext.GetUserID(r.Context())
 		if err != nil {
-			simpleError(w, http.StatusUnauthorized)
+			problem.Status(w, r, http.StatusUnauthorized, problem.CodeUnauthorized)
 			return
 		}
-		orders, err := a.store.GetUserOrders(r.Context(), *userID)
+		filter, err := parseListFilter(r, true)
+		if err != nil {
+			problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeInvalidQuery, err.Error()))
+			return
+		}
+		orders, err := a.store.GetUserOrders(r.Context(), *userID, fetchLimit(filter))
 		if err != nil {
 			logger.Log.Error("can not get orders", zap.Error(err), zap.String("user_id", userID.String()))
-			simpleError(w, http.StatusInternalServerError)
+			problem.Status(w, r, http.StatusInternalServerError, problem.CodeInternal)
 			return
 		}
+		orders = setNextCursor(w, orders, filter, func(o models.OrderItem) models.Cursor {
+			return models.Cursor{Time: o.UploadTime, ID: o.OrderID}
+		})
 		if len(orders) == 0 {
-			simpleError(w, http.StatusNoContent)
+			w.WriteHeader(http.StatusNoContent)
 			return
 		}
 
@@ -171,18 +322,17 @@ func (a *App) GetOrders() http.HandlerFunc {
 	}
 }
 
-// TODO посмотреть может ли быть балланс дробным
 func (a *App) Balance() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID, err := usercontext.GetUserID(r.Context())
 		if err != nil {
-			simpleError(w, http.StatusUnauthorized)
+			problem.Status(w, r, http.StatusUnauthorized, problem.CodeUnauthorized)
 			return
 		}
-		balance, err := a.store.Balance(r.Context(), *userID)
+		balance, err := a.store.Balance(r.Context(), *userID, a.config.PointsExpiringWindow)
 		if err != nil {
 			logger.Log.Error("failed Balance", zap.Error(err))
-			simpleError(w, http.StatusInternalServerError)
+			problem.Status(w, r, http.StatusInternalServerError, problem.CodeInternal)
 			return
 		}
 		// порядок важен
@@ -204,7 +354,7 @@ func (a *App) Withdraw() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID, err := usercontext.GetUserID(r.Context())
 		if err != nil {
-			simpleError(w, http.StatusUnauthorized)
+			problem.Status(w, r, http.StatusUnauthorized, problem.CodeUnauthorized)
 			return
 		}
 
@@ -212,30 +362,61 @@ func (a *App) Withdraw() http.HandlerFunc {
 		dec := json.NewDecoder(r.Body)
 		if err := dec.Decode(&req); err != nil {
 			logger.Log.Debug("cannot decode request JSON body", zap.Error(err))
-			http.Error(w, "bad json", http.StatusUnprocessableEntity)
+			problem.Write(w, r, problem.New(http.StatusUnprocessableEntity, problem.CodeBadJSON, err.Error()))
 			return
 		}
 
 		err = checkLuhn(req.OrderID)
 		if err != nil {
-			simpleError(w, http.StatusUnprocessableEntity)
+			problem.Write(w, r, problem.New(http.StatusUnprocessableEntity, problem.CodeInvalidOrderNumber, err.Error()))
+			return
+		}
+		if req.Sum <= 0 {
+			problem.Write(w, r, problem.New(http.StatusUnprocessableEntity, problem.CodeInvalidSum, "sum must be positive"))
 			return
 		}
 
 		err = a.store.Withdraw(r.Context(), *userID, req.OrderID, req.Sum)
 		if err != nil {
-			var code int
-			if errors.Is(err, storage.ErrNotEnoughMoney) {
-				code = http.StatusPaymentRequired
-			} else if errors.Is(err, storage.ErrOrderWithdrawnExists) {
-				code = http.StatusUnprocessableEntity
-			} else {
-				logger.Log.Error("failed Withdraw", zap.Error(err))
-				code = http.StatusInternalServerError
-			}
-			simpleError(w, code)
+			problem.Error(w, r, err, "failed Withdraw")
+			return
+		}
+	}
+}
+
+// Hold резервирует баллы под заказ при оформлении. Магазин потом
+// подтверждает резерв или снимает его, иначе резерв снимется сам через HoldTTL
+func (a *App) Hold() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := usercontext.GetUserID(r.Context())
+		if err != nil {
+			problem.Status(w, r, http.StatusUnauthorized, problem.CodeUnauthorized)
+			return
+		}
+
+		var req models.WithdrawnRequest
+		dec := json.NewDecoder(r.Body)
+		if err := dec.Decode(&req); err != nil {
+			logger.Log.Debug("cannot decode request JSON body", zap.Error(err))
+			problem.Write(w, r, problem.New(http.StatusUnprocessableEntity, problem.CodeBadJSON, err.Error()))
 			return
 		}
+		err = checkLuhn(req.OrderID)
+		if err != nil {
+			problem.Write(w, r, problem.New(http.StatusUnprocessableEntity, problem.CodeInvalidOrderNumber, err.Error()))
+			return
+		}
+		if req.Sum <= 0 {
+			problem.Write(w, r, problem.New(http.StatusUnprocessableEntity, problem.CodeInvalidSum, "sum must be positive"))
+			return
+		}
+
+		hold, err := a.store.HoldPoints(r.Context(), *userID, req.OrderID, req.Sum, a.config.HoldTTL)
+		if err != nil {
+			problem.Error(w, r, err, "failed HoldPoints")
+			return
+		}
+		writeJSON(w, hold)
 	}
 }
 
@@ -244,17 +425,25 @@ func (a *App) Withdrawals() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID, err := usercontext.GetUserID(r.Context())
 		if err != nil {
-			simpleError(w, http.StatusUnauthorized)
+			problem.Status(w, r, http.StatusUnauthorized, problem.CodeUnauthorized)
 			return
 		}
-		data, err := a.store.Withdrawals(r.Context(), *userID)
+		filter, err := parseListFilter(r, false)
+		if err != nil {
+			problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeInvalidQuery, err.Error()))
+			return
+		}
+		data, err := a.store.Withdrawals(r.Context(), *userID, fetchLimit(filter))
 		if err != nil {
 			logger.Log.Error("failed Withdrawals", zap.Error(err))
-			simpleError(w, http.StatusInternalServerError)
+			problem.Status(w, r, http.StatusInternalServerError, problem.CodeInternal)
 			return
 		}
+		data = setNextCursor(w, data, filter, func(w models.Withdrawal) models.Cursor {
+			return models.Cursor{Time: w.CreateTime, ID: w.OrderID}
+		})
 		if len(data) == 0 {
-			simpleError(w, http.StatusNoContent)
+			w.WriteHeader(http.StatusNoContent)
 			return
 		}
 		// порядок важен
@@ -0,0 +1,211 @@
+package app
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterAndLogin(t *testing.T) {
+	a := newTestApp(t, newFakeAccrual())
+	server := httptest.NewServer(a.GetRouter())
+	defer server.Close()
+	c := newTestClient(t, server)
+
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		status int
+	}{
+		{"register", "/api/user/register", `{"login":"user","password":"secret"}`, http.StatusOK},
+		{"register again", "/api/user/register", `{"login":"user","password":"other"}`, http.StatusConflict},
+		{"empty password", "/api/user/register", `{"login":"user2"}`, http.StatusBadRequest},
+		{"bad json", "/api/user/login", `{`, http.StatusBadRequest},
+		{"wrong password", "/api/user/login", `{"login":"user","password":"other"}`, http.StatusUnauthorized},
+		{"unknown user", "/api/user/login", `{"login":"nobody","password":"secret"}`, http.StatusUnauthorized},
+		{"login", "/api/user/login", `{"login":"user","password":"secret"}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := c.do(http.MethodPost, tt.path, "application/json", tt.body)
+			assert.Equal(t, tt.status, status, body)
+		})
+	}
+}
+
+func TestCreateOrder(t *testing.T) {
+	a := newTestApp(t, newFakeAccrual())
+	server := httptest.NewServer(a.GetRouter())
+	defer server.Close()
+
+	anonymous := newTestClient(t, server)
+	owner := newTestClient(t, server)
+	owner.register("owner")
+	other := newTestClient(t, server)
+	other.register("other")
+
+	tests := []struct {
+		name   string
+		client *testClient
+		order  string
+		status int
+	}{
+		{"unauthorized", anonymous, "12345678903", http.StatusUnauthorized},
+		{"empty", owner, "", http.StatusBadRequest},
+		{"bad luhn", owner, "12345678904", http.StatusUnprocessableEntity},
+		{"not digits", owner, "12a45", http.StatusUnprocessableEntity},
+		{"new", owner, "12345678903", http.StatusAccepted},
+		{"again", owner, "12345678903", http.StatusOK},
+		{"another user", other, "12345678903", http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := tt.client.do(http.MethodPost, "/api/user/orders", "text/plain", tt.order)
+			assert.Equal(t, tt.status, status, body)
+		})
+	}
+
+	status, body := owner.do(http.MethodGet, "/api/user/orders", "", "")
+	require.Equal(t, http.StatusOK, status, body)
+	var orders []models.OrderItem
+	require.NoError(t, json.Unmarshal([]byte(body), &orders))
+	require.Len(t, orders, 1)
+	assert.Equal(t, "12345678903", orders[0].OrderID)
+	assert.Equal(t, models.OrderNew, orders[0].Status)
+
+	status, _ = other.do(http.MethodGet, "/api/user/orders", "", "")
+	assert.Equal(t, http.StatusNoContent, status)
+}
+
+func TestWithdraw(t *testing.T) {
+	a := newTestApp(t, newFakeAccrual())
+	server := httptest.NewServer(a.GetRouter())
+	defer server.Close()
+	c := newTestClient(t, server)
+	c.register("user")
+
+	status, body := c.do(http.MethodPost, "/api/user/balance/withdraw", "application/json", `{"order":"2377225624","sum":10}`)
+	assert.Equal(t, http.StatusPaymentRequired, status, body)
+
+	credit(t, a, "user", "12345678903", 50*models.MoneyScale)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"bad order", `{"order":"2377225625","sum":10}`, http.StatusUnprocessableEntity},
+		{"zero sum", `{"order":"2377225624","sum":0}`, http.StatusUnprocessableEntity},
+		{"too much", `{"order":"2377225624","sum":50.001}`, http.StatusPaymentRequired},
+		{"withdraw", `{"order":"2377225624","sum":20.5}`, http.StatusOK},
+		{"same order", `{"order":"2377225624","sum":1}`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := c.do(http.MethodPost, "/api/user/balance/withdraw", "application/json", tt.body)
+			assert.Equal(t, tt.status, status, body)
+		})
+	}
+
+	status, body = c.do(http.MethodGet, "/api/user/balance", "", "")
+	require.Equal(t, http.StatusOK, status, body)
+	var balance models.Balance
+	require.NoError(t, json.Unmarshal([]byte(body), &balance))
+	assert.Equal(t, models.Money(29500), balance.Current)
+	assert.Equal(t, models.Money(20500), balance.Withdrawn)
+
+	status, body = c.do(http.MethodGet, "/api/user/withdrawals", "", "")
+	require.Equal(t, http.StatusOK, status, body)
+	var withdrawals []models.Withdrawal
+	require.NoError(t, json.Unmarshal([]byte(body), &withdrawals))
+	require.Len(t, withdrawals, 1)
+	assert.Equal(t, "2377225624", withdrawals[0].OrderID)
+	assert.Equal(t, models.Money(20500), withdrawals[0].Sum)
+}
+
+func TestLoginUpgradesLegacyHash(t *testing.T) {
+	a := newTestApp(t, newFakeAccrual())
+	server := httptest.NewServer(a.GetRouter())
+	defer server.Close()
+	c := newTestClient(t, server)
+
+	// так хэшировались пароли до argon2id
+	h := hmac.New(sha256.New, []byte("somesecret"))
+	h.Write([]byte("secret"))
+	legacy := hex.EncodeToString(h.Sum(nil))
+	ctx := context.Background()
+	_, err := a.store.CreateUser(ctx, "old", legacy)
+	require.NoError(t, err)
+
+	status, body := c.do(http.MethodPost, "/api/user/login", "application/json", `{"login":"old","password":"other"}`)
+	require.Equal(t, http.StatusUnauthorized, status, body)
+	user, err := a.store.GetUser(ctx, "old")
+	require.NoError(t, err)
+	assert.Equal(t, legacy, user.Hash)
+
+	status, body = c.do(http.MethodPost, "/api/user/login", "application/json", `{"login":"old","password":"secret"}`)
+	require.Equal(t, http.StatusOK, status, body)
+	user, err = a.store.GetUser(ctx, "old")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(user.Hash, "$argon2id$"), user.Hash)
+
+	// вход с новым хэшем
+	status, body = c.do(http.MethodPost, "/api/user/login", "application/json", `{"login":"old","password":"secret"}`)
+	assert.Equal(t, http.StatusOK, status, body)
+}
+
+func TestLegacyCookieUpgrade(t *testing.T) {
+	a := newTestApp(t, newFakeAccrual())
+	server := httptest.NewServer(a.GetRouter())
+	defer server.Close()
+	ctx := context.Background()
+	userID, err := a.store.CreateUser(ctx, "old", "hash")
+	require.NoError(t, err)
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	// так подписывались cookie до сессий: userid.signature
+	legacyCookie := func(secret string) *testClient {
+		h := hmac.New(sha256.New, []byte(secret))
+		h.Write(userID[:])
+		c := newTestClient(t, server)
+		c.client.Jar.SetCookies(serverURL, []*http.Cookie{{
+			Name:  "user_id",
+			Value: userID.String() + "." + hex.EncodeToString(h.Sum(nil)),
+			Path:  "/",
+		}})
+		return c
+	}
+
+	c := legacyCookie("other secret")
+	status, body := c.do(http.MethodGet, "/api/user/balance", "", "")
+	assert.Equal(t, http.StatusUnauthorized, status, body)
+
+	c = legacyCookie("newsomesecret")
+	status, body = c.do(http.MethodGet, "/api/user/balance", "", "")
+	require.Equal(t, http.StatusOK, status, body)
+	// старую cookie заменили новой с сессией
+	cookies := c.client.Jar.Cookies(serverURL)
+	require.Len(t, cookies, 1)
+	assert.Len(t, strings.Split(cookies[0].Value, "."), 5)
+
+	status, body = c.do(http.MethodGet, "/api/user/balance", "", "")
+	require.Equal(t, http.StatusOK, status, body)
+	// у новой cookie есть сессия, ее можно завершить
+	status, body = c.do(http.MethodPost, "/api/user/logout", "", "")
+	require.Equal(t, http.StatusOK, status, body)
+	status, body = c.do(http.MethodGet, "/api/user/balance", "", "")
+	assert.Equal(t, http.StatusUnauthorized, status, body)
+}
@@ -0,0 +1,185 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/serg2014/go-musthave-diploma/internal/app/accrual"
+	"github.com/serg2014/go-musthave-diploma/internal/app/storage"
+	"github.com/serg2014/go-musthave-diploma/internal/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	// по скольким последним запросам в accrual считаем долю ошибок
+	accrualWindowSize = 100
+	// меньше запросов - долю ошибок не оцениваем
+	accrualWindowMin = 10
+	// сколько ждем бд в проверках
+	healthCheckTimeout = 2 * time.Second
+)
+
+const (
+	checkOK   = "ok"
+	checkFail = "fail"
+)
+
+// health состояние процесса для /healthz и /readyz
+type health struct {
+	shuttingDown atomic.Bool
+	// unix nano последней итерации цикла ProcessOrders
+	lastLoop atomic.Int64
+	// unix nano последнего успешного забора заказов
+	lastTick atomic.Int64
+
+	mu sync.Mutex
+	// кольцевой буфер результатов запросов в accrual, true - ошибка
+	accrual    [accrualWindowSize]bool
+	accrualPos int
+	accrualLen int
+}
+
+func newHealth() *health {
+	h := &health{}
+	// до первого тика считаем что обработчик только что стартовал
+	now := time.Now().UnixNano()
+	h.lastLoop.Store(now)
+	h.lastTick.Store(now)
+	return h
+}
+
+func (h *health) loop() {
+	h.lastLoop.Store(time.Now().UnixNano())
+}
+
+func (h *health) tick() {
+	now := time.Now().UnixNano()
+	h.lastLoop.Store(now)
+	h.lastTick.Store(now)
+}
+
+func (h *health) accrualResult(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// открытый breaker тоже значит что сервис недоступен
+	h.accrual[h.accrualPos] = accrual.IsFailure(err) || errors.Is(err, accrual.ErrCircuitOpen)
+	h.accrualPos = (h.accrualPos + 1) % accrualWindowSize
+	if h.accrualLen < accrualWindowSize {
+		h.accrualLen++
+	}
+}
+
+// accrualErrorRate доля ошибок и сколько запросов в окне
+func (h *health) accrualErrorRate() (float64, int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.accrualLen == 0 {
+		return 0, 0
+	}
+	failures := 0
+	for i := range h.accrualLen {
+		if h.accrual[i] {
+			failures++
+		}
+	}
+	return float64(failures) / float64(h.accrualLen), h.accrualLen
+}
+
+func since(unixNano int64) time.Duration {
+	return time.Since(time.Unix(0, unixNano))
+}
+
+// SetShuttingDown readyz начинает отвечать 503, сервер при этом еще работает
+func (a *App) SetShuttingDown() {
+	a.health.shuttingDown.Store(true)
+	// SSE соединения закрываем сразу, клиенты переподключатся к другому экземпляру
+	a.events.close()
+}
+
+type healthCheck struct {
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type healthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]healthCheck `json:"checks"`
+}
+
+func (r *healthResponse) add(name string, ok bool, detail string) {
+	status := checkOK
+	if !ok {
+		status = checkFail
+		r.Status = checkFail
+	}
+	r.Checks[name] = healthCheck{Status: status, Detail: detail}
+}
+
+func writeHealth(w http.ResponseWriter, resp *healthResponse) {
+	code := http.StatusOK
+	if resp.Status != checkOK {
+		code = http.StatusServiceUnavailable
+		logger.Log.Info("health check failed", zap.Any("checks", resp.Checks))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Log.Error("error encoding response", zap.Error(err))
+	}
+}
+
+// healthz жив ли процесс: цикл обработки заказов не завис.
+// Зависимости тут не проверяем, иначе недоступная бд приведет к перезапуску
+func (a *App) healthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := &healthResponse{Status: checkOK, Checks: make(map[string]healthCheck)}
+		age := since(a.health.lastLoop.Load())
+		resp.add("processor", age <= a.config.HealthMaxTickAge, fmt.Sprintf("last loop %s ago", age.Round(time.Millisecond)))
+		writeHealth(w, resp)
+	}
+}
+
+// readyz можно ли слать трафик: бд доступна, миграции применены,
+// заказы обрабатываются, accrual отвечает, сервер не останавливается
+func (a *App) readyz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := &healthResponse{Status: checkOK, Checks: make(map[string]healthCheck)}
+		resp.add("shutdown", !a.health.shuttingDown.Load(), "")
+
+		ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+		defer cancel()
+		if err := a.store.Ping(ctx); err != nil {
+			resp.add("db", false, err.Error())
+		} else {
+			resp.add("db", true, "")
+		}
+
+		version, dirty, err := a.store.MigrationVersion(ctx)
+		switch {
+		case err != nil:
+			resp.add("migrations", false, err.Error())
+		case dirty:
+			resp.add("migrations", false, fmt.Sprintf("version %d is dirty", version))
+		case version < storage.SchemaVersion:
+			resp.add("migrations", false, fmt.Sprintf("version %d, expected %d", version, storage.SchemaVersion))
+		default:
+			resp.add("migrations", true, fmt.Sprintf("version %d", version))
+		}
+
+		age := since(a.health.lastTick.Load())
+		resp.add("processor", age <= a.config.HealthMaxTickAge, fmt.Sprintf("last tick %s ago", age.Round(time.Millisecond)))
+
+		rate, n := a.health.accrualErrorRate()
+		detail := fmt.Sprintf("error rate %.2f of last %d requests", rate, n)
+		resp.add("accrual", n < accrualWindowMin || rate <= a.config.HealthMaxAccrualErrorRate, detail)
+
+		writeHealth(w, resp)
+	}
+}
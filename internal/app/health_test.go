@@ -0,0 +1,50 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/serg2014/go-musthave-diploma/internal/app/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// versionStore отдает заданную версию схемы
+type versionStore struct {
+	storage.Storager
+	version uint
+	dirty   bool
+}
+
+func (s *versionStore) MigrationVersion(ctx context.Context) (uint, bool, error) {
+	return s.version, s.dirty, nil
+}
+
+func TestReadyzMigrations(t *testing.T) {
+	tests := []struct {
+		name    string
+		version uint
+		dirty   bool
+		status  string
+	}{
+		{"actual", storage.SchemaVersion, false, checkOK},
+		{"newer", storage.SchemaVersion + 1, false, checkOK},
+		{"behind", storage.SchemaVersion - 1, false, checkFail},
+		{"dirty", storage.SchemaVersion, true, checkFail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApp(t, newFakeAccrual())
+			a.store = &versionStore{Storager: a.store, version: tt.version, dirty: tt.dirty}
+			w := httptest.NewRecorder()
+			a.readyz()(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			var resp healthResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.Equal(t, tt.status, resp.Checks["migrations"].Status, resp.Checks["migrations"].Detail)
+		})
+	}
+}
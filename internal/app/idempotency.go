@@ -0,0 +1,153 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+
+	usercontext "github.com/serg2014/go-musthave-diploma/internal/app/context"
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+	"github.com/serg2014/go-musthave-diploma/internal/app/problem"
+	"github.com/serg2014/go-musthave-diploma/internal/app/storage"
+	"github.com/serg2014/go-musthave-diploma/internal/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotentReplayedHeader  = "Idempotent-Replayed"
+	idempotencyKeyMaxLen      = 255
+	idempotencyMaxRequestBody = 1 << 20
+)
+
+// recordWriter пишет ответ клиенту и запоминает его для повторов
+type recordWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *recordWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// fingerprint запроса: тот же ключ с другим телом или на другой адрес - ошибка клиента
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.Path+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotent повторный запрос с тем же Idempotency-Key получает сохраненный ответ.
+// Ключи у каждого пользователя свои. Без заголовка запрос выполняется как обычно
+func (a *App) idempotent(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > idempotencyKeyMaxLen {
+			problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeBadRequest, "idempotency key is too long"))
+			return
+		}
+		userID, err := usercontext.GetUserID(r.Context())
+		if err != nil {
+			problem.Status(w, r, http.StatusUnauthorized, problem.CodeUnauthorized)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, idempotencyMaxRequestBody+1))
+		if err != nil {
+			problem.Status(w, r, http.StatusBadRequest, problem.CodeBadRequest)
+			return
+		}
+		if len(body) > idempotencyMaxRequestBody {
+			problem.Status(w, r, http.StatusRequestEntityTooLarge, problem.CodePayloadTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		saved, err := a.store.BeginIdempotency(r.Context(), *userID, key, fingerprint(r, body),
+			a.config.IdempotencyTTL, a.config.IdempotencyLockTTL)
+		if err != nil {
+			if errors.Is(err, storage.ErrIdempotencyInProgress) {
+				w.Header().Set("Retry-After", "1")
+			}
+			problem.Error(w, r, err, "failed BeginIdempotency")
+			return
+		}
+		if saved != nil {
+			replay(w, saved)
+			return
+		}
+
+		// запрос не должен выполняться дольше, чем занят ключ: иначе повтор
+		// займет ключ и выполнится второй раз
+		ctx, cancel := context.WithTimeout(r.Context(), a.config.IdempotencyLockTTL)
+		defer cancel()
+		rw := &recordWriter{ResponseWriter: w}
+		h.ServeHTTP(rw, r.WithContext(ctx))
+		if rw.status == 0 {
+			rw.status = http.StatusOK
+		}
+
+		// ответ уже отдан клиенту, сохраняем даже если он отключился
+		ctx = context.WithoutCancel(r.Context())
+		if rw.status >= http.StatusInternalServerError {
+			// запрос не выполнен, повтор с тем же ключом должен выполниться заново
+			if err := a.store.DeleteIdempotency(ctx, *userID, key); err != nil {
+				logger.Log.Error("failed DeleteIdempotency", zap.Error(err))
+			}
+			return
+		}
+		err = a.store.FinishIdempotency(ctx, *userID, key, &models.IdempotentResponse{
+			Status:      rw.status,
+			ContentType: rw.Header().Get("Content-Type"),
+			Body:        rw.body.Bytes(),
+		})
+		if err != nil {
+			logger.Log.Error("failed FinishIdempotency", zap.Error(err))
+		}
+	})
+}
+
+// requireIdempotencyKey для запросов, повтор которых нельзя распознать по телу,
+// например перевод баллов. Ставится перед idempotent
+func requireIdempotencyKey(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(IdempotencyKeyHeader) == "" {
+			problem.Write(w, r, problem.New(http.StatusPreconditionRequired, problem.CodeIdempotencyKeyMissing,
+				IdempotencyKeyHeader+" header is required"))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func replay(w http.ResponseWriter, saved *models.IdempotentResponse) {
+	if saved.ContentType != "" {
+		w.Header().Set("Content-Type", saved.ContentType)
+	}
+	w.Header().Set(IdempotentReplayedHeader, "true")
+	w.WriteHeader(saved.Status)
+	if _, err := w.Write(saved.Body); err != nil {
+		logger.Log.Error("error writing replayed response", zap.Error(err))
+	}
+}
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+	"github.com/serg2014/go-musthave-diploma/internal/logger"
+	"go.uber.org/zap"
+)
+
+// сколько ждем хранилище при сборе метрик
+const queueStatsTimeout = 2 * time.Second
+
+type QueueStatser interface {
+	QueueStats(ctx context.Context) (*models.QueueStats, error)
+}
+
+var (
+	queueTotalDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "orders_for_process"),
+		"Orders waiting for accrual.",
+		nil, nil,
+	)
+	queueReadyDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "orders_for_process_ready"),
+		"Orders that are not locked and whose next attempt is due.",
+		nil, nil,
+	)
+	queueLockedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "orders_for_process_locked"),
+		"Orders locked by an instance.",
+		[]string{"who"}, nil,
+	)
+)
+
+// queueCollector спрашивает хранилище на каждом scrape
+type queueCollector struct {
+	store QueueStatser
+}
+
+func (c *queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueTotalDesc
+	ch <- queueReadyDesc
+	ch <- queueLockedDesc
+}
+
+func (c *queueCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), queueStatsTimeout)
+	defer cancel()
+	stats, err := c.store.QueueStats(ctx)
+	if err != nil {
+		logger.Log.Error("failed QueueStats", zap.Error(err))
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(queueTotalDesc, prometheus.GaugeValue, float64(stats.Total))
+	ch <- prometheus.MustNewConstMetric(queueReadyDesc, prometheus.GaugeValue, float64(stats.Ready))
+	for who, count := range stats.Locked {
+		ch <- prometheus.MustNewConstMetric(queueLockedDesc, prometheus.GaugeValue, float64(count), who)
+	}
+}
+
+// RegisterQueue добавляет метрики очереди orders_for_process.
+// Повторный вызов (новое приложение в тестах) заменяет хранилище
+func RegisterQueue(store QueueStatser) error {
+	collector := &queueCollector{store: store}
+	err := Registry.Register(collector)
+	var registered prometheus.AlreadyRegisteredError
+	if errors.As(err, &registered) {
+		Registry.Unregister(registered.ExistingCollector)
+		return Registry.Register(collector)
+	}
+	return err
+}
@@ -0,0 +1,257 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+	"github.com/serg2014/go-musthave-diploma/internal/app/storage"
+)
+
+// instrumentedStorage считает время и ошибки каждого метода хранилища
+type instrumentedStorage struct {
+	next storage.Storager
+}
+
+// NewStorage оборачивает хранилище метриками
+func NewStorage(s storage.Storager) storage.Storager {
+	return &instrumentedStorage{next: s}
+}
+
+// observe использовать как defer observe("Method", time.Now(), &err)
+func observe(method string, start time.Time, err *error) {
+	storageDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if *err != nil {
+		storageErrors.WithLabelValues(method).Inc()
+	}
+}
+
+func (s *instrumentedStorage) CreateUser(ctx context.Context, login, passwordHash string) (_ *models.UserID, err error) {
+	defer observe("CreateUser", time.Now(), &err)
+	return s.next.CreateUser(ctx, login, passwordHash)
+}
+
+func (s *instrumentedStorage) GetUser(ctx context.Context, login string) (_ *storage.User, err error) {
+	defer observe("GetUser", time.Now(), &err)
+	return s.next.GetUser(ctx, login)
+}
+
+func (s *instrumentedStorage) UpdateUserHash(ctx context.Context, userID models.UserID, passwordHash string) (err error) {
+	defer observe("UpdateUserHash", time.Now(), &err)
+	return s.next.UpdateUserHash(ctx, userID, passwordHash)
+}
+
+func (s *instrumentedStorage) CreateSession(ctx context.Context, userID models.UserID, ttl time.Duration) (_ *models.Session, err error) {
+	defer observe("CreateSession", time.Now(), &err)
+	return s.next.CreateSession(ctx, userID, ttl)
+}
+
+func (s *instrumentedStorage) GetActiveSession(ctx context.Context, sessionID models.SessionID) (_ *models.Session, err error) {
+	defer observe("GetActiveSession", time.Now(), &err)
+	return s.next.GetActiveSession(ctx, sessionID)
+}
+
+func (s *instrumentedStorage) RevokeSession(ctx context.Context, sessionID models.SessionID) (err error) {
+	defer observe("RevokeSession", time.Now(), &err)
+	return s.next.RevokeSession(ctx, sessionID)
+}
+
+func (s *instrumentedStorage) RevokeUserSessions(ctx context.Context, userID models.UserID) (err error) {
+	defer observe("RevokeUserSessions", time.Now(), &err)
+	return s.next.RevokeUserSessions(ctx, userID)
+}
+
+func (s *instrumentedStorage) CreateOrder(ctx context.Context, orderID string, userID models.UserID) (err error) {
+	defer observe("CreateOrder", time.Now(), &err)
+	return s.next.CreateOrder(ctx, orderID, userID)
+}
+
+func (s *instrumentedStorage) GetUserOrders(ctx context.Context, userID models.UserID, filter *models.ListFilter) (_ models.Orders, err error) {
+	defer observe("GetUserOrders", time.Now(), &err)
+	return s.next.GetUserOrders(ctx, userID, filter)
+}
+
+func (s *instrumentedStorage) Balance(ctx context.Context, userID models.UserID, expiringWithin time.Duration) (_ *models.Balance, err error) {
+	defer observe("Balance", time.Now(), &err)
+	return s.next.Balance(ctx, userID, expiringWithin)
+}
+
+func (s *instrumentedStorage) Withdraw(ctx context.Context, userID models.UserID, orderID string, sum models.Money) (err error) {
+	defer observe("Withdraw", time.Now(), &err)
+	return s.next.Withdraw(ctx, userID, orderID, sum)
+}
+
+func (s *instrumentedStorage) Withdrawals(ctx context.Context, userID models.UserID, filter *models.ListFilter) (_ models.Withdrawals, err error) {
+	defer observe("Withdrawals", time.Now(), &err)
+	return s.next.Withdrawals(ctx, userID, filter)
+}
+
+func (s *instrumentedStorage) CleanupAfterCrash(ctx context.Context, t time.Duration) (err error) {
+	defer observe("CleanupAfterCrash", time.Now(), &err)
+	return s.next.CleanupAfterCrash(ctx, t)
+}
+
+func (s *instrumentedStorage) GetOrdersForProcess(ctx context.Context, who string, limit uint) (_ models.ProcessingOrders, err error) {
+	defer observe("GetOrdersForProcess", time.Now(), &err)
+	return s.next.GetOrdersForProcess(ctx, who, limit)
+}
+
+func (s *instrumentedStorage) UpdateOrders(ctx context.Context, data []*models.AccrualOrderItem, who string) (err error) {
+	defer observe("UpdateOrders", time.Now(), &err)
+	return s.next.UpdateOrders(ctx, data, who)
+}
+
+func (s *instrumentedStorage) CleanOrdersForProcess(ctx context.Context, who string) (err error) {
+	defer observe("CleanOrdersForProcess", time.Now(), &err)
+	return s.next.CleanOrdersForProcess(ctx, who)
+}
+
+func (s *instrumentedStorage) ListDeadLetters(ctx context.Context, limit uint) (_ models.DeadLetters, err error) {
+	defer observe("ListDeadLetters", time.Now(), &err)
+	return s.next.ListDeadLetters(ctx, limit)
+}
+
+func (s *instrumentedStorage) RequeueDeadLetter(ctx context.Context, orderID models.OrderID) (err error) {
+	defer observe("RequeueDeadLetter", time.Now(), &err)
+	return s.next.RequeueDeadLetter(ctx, orderID)
+}
+
+// ListenNewOrders долгоживущая подписка, время не считаем
+func (s *instrumentedStorage) ListenNewOrders(ctx context.Context) (<-chan struct{}, error) {
+	return s.next.ListenNewOrders(ctx)
+}
+
+func (s *instrumentedStorage) QueueStats(ctx context.Context) (_ *models.QueueStats, err error) {
+	defer observe("QueueStats", time.Now(), &err)
+	return s.next.QueueStats(ctx)
+}
+
+func (s *instrumentedStorage) Ping(ctx context.Context) (err error) {
+	defer observe("Ping", time.Now(), &err)
+	return s.next.Ping(ctx)
+}
+
+func (s *instrumentedStorage) MigrationVersion(ctx context.Context) (_ uint, _ bool, err error) {
+	defer observe("MigrationVersion", time.Now(), &err)
+	return s.next.MigrationVersion(ctx)
+}
+
+func (s *instrumentedStorage) BeginIdempotency(ctx context.Context, userID models.UserID, key, fingerprint string, ttl, lockTTL time.Duration) (_ *models.IdempotentResponse, err error) {
+	defer observe("BeginIdempotency", time.Now(), &err)
+	return s.next.BeginIdempotency(ctx, userID, key, fingerprint, ttl, lockTTL)
+}
+
+func (s *instrumentedStorage) FinishIdempotency(ctx context.Context, userID models.UserID, key string, resp *models.IdempotentResponse) (err error) {
+	defer observe("FinishIdempotency", time.Now(), &err)
+	return s.next.FinishIdempotency(ctx, userID, key, resp)
+}
+
+func (s *instrumentedStorage) DeleteIdempotency(ctx context.Context, userID models.UserID, key string) (err error) {
+	defer observe("DeleteIdempotency", time.Now(), &err)
+	return s.next.DeleteIdempotency(ctx, userID, key)
+}
+
+func (s *instrumentedStorage) DeleteExpiredIdempotency(ctx context.Context) (err error) {
+	defer observe("DeleteExpiredIdempotency", time.Now(), &err)
+	return s.next.DeleteExpiredIdempotency(ctx)
+}
+
+func (s *instrumentedStorage) SetWebhook(ctx context.Context, hook *models.Webhook) (err error) {
+	defer observe("SetWebhook", time.Now(), &err)
+	return s.next.SetWebhook(ctx, hook)
+}
+
+func (s *instrumentedStorage) GetWebhook(ctx context.Context, userID models.UserID) (_ *models.Webhook, err error) {
+	defer observe("GetWebhook", time.Now(), &err)
+	return s.next.GetWebhook(ctx, userID)
+}
+
+func (s *instrumentedStorage) DeleteWebhook(ctx context.Context, userID models.UserID) (err error) {
+	defer observe("DeleteWebhook", time.Now(), &err)
+	return s.next.DeleteWebhook(ctx, userID)
+}
+
+func (s *instrumentedStorage) WebhookDeliveries(ctx context.Context, userID models.UserID, filter *models.ListFilter) (_ models.WebhookDeliveries, err error) {
+	defer observe("WebhookDeliveries", time.Now(), &err)
+	return s.next.WebhookDeliveries(ctx, userID, filter)
+}
+
+func (s *instrumentedStorage) ClaimWebhookDeliveries(ctx context.Context, who string, limit uint) (_ models.WebhookDeliveries, err error) {
+	defer observe("ClaimWebhookDeliveries", time.Now(), &err)
+	return s.next.ClaimWebhookDeliveries(ctx, who, limit)
+}
+
+func (s *instrumentedStorage) FinishWebhookDeliveries(ctx context.Context, results []*models.WebhookResult, who string) (err error) {
+	defer observe("FinishWebhookDeliveries", time.Now(), &err)
+	return s.next.FinishWebhookDeliveries(ctx, results, who)
+}
+
+func (s *instrumentedStorage) UserEvents(ctx context.Context, userID models.UserID, afterID int64, limit uint) (_ models.UserEvents, err error) {
+	defer observe("UserEvents", time.Now(), &err)
+	return s.next.UserEvents(ctx, userID, afterID, limit)
+}
+
+func (s *instrumentedStorage) LastUserEventID(ctx context.Context, userID models.UserID) (_ int64, err error) {
+	defer observe("LastUserEventID", time.Now(), &err)
+	return s.next.LastUserEventID(ctx, userID)
+}
+
+// ListenUserEvents долгоживущая подписка, время не считаем
+func (s *instrumentedStorage) ListenUserEvents(ctx context.Context) (<-chan models.UserID, error) {
+	return s.next.ListenUserEvents(ctx)
+}
+
+func (s *instrumentedStorage) DeleteExpiredUserEvents(ctx context.Context, ttl time.Duration) (err error) {
+	defer observe("DeleteExpiredUserEvents", time.Now(), &err)
+	return s.next.DeleteExpiredUserEvents(ctx, ttl)
+}
+
+func (s *instrumentedStorage) ExpirePoints(ctx context.Context, limit uint) (_ int, err error) {
+	defer observe("ExpirePoints", time.Now(), &err)
+	return s.next.ExpirePoints(ctx, limit)
+}
+
+func (s *instrumentedStorage) Transfer(ctx context.Context, fromUserID models.UserID, toLogin string, sum, dailyLimit models.Money) (_ *models.Transfer, err error) {
+	defer observe("Transfer", time.Now(), &err)
+	return s.next.Transfer(ctx, fromUserID, toLogin, sum, dailyLimit)
+}
+
+func (s *instrumentedStorage) Transfers(ctx context.Context, userID models.UserID, filter *models.ListFilter) (_ models.Transfers, err error) {
+	defer observe("Transfers", time.Now(), &err)
+	return s.next.Transfers(ctx, userID, filter)
+}
+
+func (s *instrumentedStorage) ReverseWithdrawal(ctx context.Context, reversal *models.Reversal) (err error) {
+	defer observe("ReverseWithdrawal", time.Now(), &err)
+	return s.next.ReverseWithdrawal(ctx, reversal)
+}
+
+func (s *instrumentedStorage) HoldPoints(ctx context.Context, userID models.UserID, orderID models.OrderID, sum models.Money, ttl time.Duration) (_ *models.Hold, err error) {
+	defer observe("HoldPoints", time.Now(), &err)
+	return s.next.HoldPoints(ctx, userID, orderID, sum, ttl)
+}
+
+func (s *instrumentedStorage) CaptureHold(ctx context.Context, orderID models.OrderID) (_ *models.Hold, err error) {
+	defer observe("CaptureHold", time.Now(), &err)
+	return s.next.CaptureHold(ctx, orderID)
+}
+
+func (s *instrumentedStorage) ReleaseHold(ctx context.Context, orderID models.OrderID) (_ *models.Hold, err error) {
+	defer observe("ReleaseHold", time.Now(), &err)
+	return s.next.ReleaseHold(ctx, orderID)
+}
+
+func (s *instrumentedStorage) ReleaseStaleHolds(ctx context.Context, limit uint) (_ int, err error) {
+	defer observe("ReleaseStaleHolds", time.Now(), &err)
+	return s.next.ReleaseStaleHolds(ctx, limit)
+}
+
+func (s *instrumentedStorage) AdjustBalance(ctx context.Context, adj *models.Adjustment, pointsTTL time.Duration) (err error) {
+	defer observe("AdjustBalance", time.Now(), &err)
+	return s.next.AdjustBalance(ctx, adj, pointsTTL)
+}
+
+func (s *instrumentedStorage) Adjustments(ctx context.Context, userID models.UserID, filter *models.ListFilter) (_ models.Adjustments, err error) {
+	defer observe("Adjustments", time.Now(), &err)
+	return s.next.Adjustments(ctx, userID, filter)
+}
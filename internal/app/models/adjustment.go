@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AdjustmentRequest struct {
+	// больше нуля - начислить, меньше - списать
+	Sum    Money  `json:"sum"`
+	Reason string `json:"reason"`
+}
+
+// Adjustment ручная корректировка баланса администратором
+type Adjustment struct {
+	ID     uuid.UUID `json:"id"`
+	UserID UserID    `json:"-"`
+	Sum    Money     `json:"sum"`
+	Reason string    `json:"reason"`
+	// кто исправил баланс, пользователю не показываем
+	Admin     string    `json:"admin,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+type Adjustments []Adjustment
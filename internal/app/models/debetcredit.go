@@ -5,4 +5,21 @@ type DebetCreditType string
 const (
 	Debet  DebetCreditType = "DEBET"
 	Credit DebetCreditType = "CREDIT"
+	// несписанный остаток начисления сгорел
+	Expire DebetCreditType = "EXPIRE"
+	// перевод другому пользователю и полученный перевод
+	TransferOut DebetCreditType = "TRANSFER_OUT"
+	TransferIn  DebetCreditType = "TRANSFER_IN"
+	// возврат отмененного списания
+	CreditReversal DebetCreditType = "REVERSAL"
+	// резерв баллов под заказ и его снятие
+	CreditHold    DebetCreditType = "HOLD"
+	CreditRelease DebetCreditType = "RELEASE"
+	// ручная корректировка: положительная - партия баллов, у отрицательной остатка нет
+	CreditAdjustment DebetCreditType = "ADJUSTMENT"
 )
+
+// IsLot запись - партия баллов с остатком и сроком сгорания
+func (t DebetCreditType) IsLot() bool {
+	return t == Debet || t == TransferIn || t == CreditReversal || t == CreditRelease || t == CreditAdjustment
+}
@@ -0,0 +1,159 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventOrderStatusChanged EventType = "order.status_changed"
+	EventBalanceCredited    EventType = "balance.credited"
+	EventBalanceWithdrawn   EventType = "balance.withdrawn"
+	EventBalanceExpired     EventType = "balance.expired"
+	// баллы зарезервированы под заказ и резерв снят
+	EventBalanceHeld     EventType = "balance.held"
+	EventBalanceReleased EventType = "balance.hold_released"
+	// баланс исправлен администратором
+	EventBalanceAdjusted EventType = "balance.adjusted"
+	// списание отменено, баллы вернулись
+	EventBalanceWithdrawalReversed EventType = "balance.withdrawal_reversed"
+	// перевод баллов отправлен и получен
+	EventBalanceTransferSent     EventType = "balance.transfer_sent"
+	EventBalanceTransferReceived EventType = "balance.transfer_received"
+)
+
+// Event изменение заказа или баланса пользователя.
+// Уходит в журнал событий (SSE) и на вебхук
+type Event struct {
+	Type           EventType   `json:"event"`
+	OrderID        OrderID     `json:"order,omitempty"`
+	Status         OrderStatus `json:"status,omitempty"`
+	PreviousStatus OrderStatus `json:"previous_status,omitempty"`
+	Accrual        *Money      `json:"accrual,omitempty"`
+	// сумма списания или сгоревших баллов
+	Sum *Money `json:"sum,omitempty"`
+	// для переводов: id перевода и логин второй стороны
+	TransferID   string `json:"transfer,omitempty"`
+	Counterparty string `json:"counterparty,omitempty"`
+	// для корректировок: id и причина
+	AdjustmentID string    `json:"adjustment,omitempty"`
+	Reason       string    `json:"reason,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// OrderEvents события изменения заказа: смена статуса и начисление баллов
+func OrderEvents(orderID OrderID, prev, status OrderStatus, accrual *Money, credited bool) []Event {
+	now := time.Now().UTC()
+	events := make([]Event, 0, 2)
+	if prev != status {
+		events = append(events, Event{
+			Type:           EventOrderStatusChanged,
+			OrderID:        orderID,
+			Status:         status,
+			PreviousStatus: prev,
+			Accrual:        accrual,
+			CreatedAt:      now,
+		})
+	}
+	if credited && accrual != nil {
+		events = append(events, Event{
+			Type:      EventBalanceCredited,
+			OrderID:   orderID,
+			Status:    status,
+			Accrual:   accrual,
+			CreatedAt: now,
+		})
+	}
+	return events
+}
+
+// WithdrawEvent событие списания баллов
+func WithdrawEvent(orderID OrderID, sum Money) Event {
+	return Event{
+		Type:      EventBalanceWithdrawn,
+		OrderID:   orderID,
+		Sum:       &sum,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// HoldEvent событие резерва баллов под заказ
+func HoldEvent(orderID OrderID, sum Money) Event {
+	return Event{
+		Type:      EventBalanceHeld,
+		OrderID:   orderID,
+		Sum:       &sum,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// ReleaseEvent событие снятия резерва, баллы вернулись
+func ReleaseEvent(orderID OrderID, sum Money) Event {
+	return Event{
+		Type:      EventBalanceReleased,
+		OrderID:   orderID,
+		Sum:       &sum,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// ReversalEvent событие отмены списания за заказ
+func ReversalEvent(orderID OrderID, sum Money) Event {
+	return Event{
+		Type:      EventBalanceWithdrawalReversed,
+		OrderID:   orderID,
+		Sum:       &sum,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// ExpireEvent событие сгорания остатка начисления за заказ
+func ExpireEvent(orderID OrderID, sum Money) Event {
+	return Event{
+		Type:      EventBalanceExpired,
+		OrderID:   orderID,
+		Sum:       &sum,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// TransferEvent событие перевода для одной из сторон
+func TransferEvent(t *Transfer) Event {
+	eventType := EventBalanceTransferSent
+	if t.Direction == TransferReceived {
+		eventType = EventBalanceTransferReceived
+	}
+	sum := t.Sum
+	return Event{
+		Type:         eventType,
+		Sum:          &sum,
+		TransferID:   t.ID.String(),
+		Counterparty: t.Counterparty,
+		CreatedAt:    time.Now().UTC(),
+	}
+}
+
+// AdjustmentEvent событие ручной корректировки баланса, сумма со знаком
+func AdjustmentEvent(a *Adjustment) Event {
+	sum := a.Sum
+	return Event{
+		Type:         EventBalanceAdjusted,
+		Sum:          &sum,
+		AdjustmentID: a.ID.String(),
+		Reason:       a.Reason,
+		CreatedAt:    time.Now().UTC(),
+	}
+}
+
+// UserEvent запись журнала событий пользователя. ID растет,
+// по нему клиент продолжает поток после переподключения (Last-Event-ID)
+type UserEvent struct {
+	ID        int64
+	UserID    UserID
+	Type      EventType
+	Data      json.RawMessage
+	CreatedAt time.Time
+}
+type UserEvents []UserEvent
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type HoldStatus string
+
+const (
+	HoldHeld     HoldStatus = "HELD"
+	HoldCaptured HoldStatus = "CAPTURED"
+	HoldReleased HoldStatus = "RELEASED"
+)
+
+// Hold резерв баллов под заказ: подтверждается списанием или снимается.
+// После снятия заказ можно зарезервировать снова, уже с другим ID
+type Hold struct {
+	// под ним в истории баланса записи HOLD и RELEASE
+	ID      uuid.UUID  `json:"-"`
+	OrderID OrderID    `json:"order"`
+	UserID  UserID     `json:"-"`
+	Sum     Money      `json:"sum"`
+	Status  HoldStatus `json:"status"`
+	// после этого времени резерв снимается автоматически
+	ExpiresAt  time.Time  `json:"expires_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
@@ -0,0 +1,8 @@
+package models
+
+// IdempotentResponse сохраненный ответ на запрос с Idempotency-Key
+type IdempotentResponse struct {
+	Status      int
+	ContentType string
+	Body        []byte
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 )
 
@@ -10,20 +12,35 @@ type RegisterUser struct {
 }
 
 type Balance struct {
-	Current   float32 `json:"current"`
-	Withdrawn float32 `json:"withdrawn"`
+	Current   Money `json:"current"`
+	Withdrawn Money `json:"withdrawn"`
+	// зарезервировано под заказы, еще не списано и не возвращено
+	Held Money `json:"held"`
+	// сгоревшие баллы
+	Expired Money `json:"expired"`
+	// баллы, которые скоро сгорят, по дате сгорания
+	Expiring []ExpiringPoints `json:"expiring,omitempty"`
+}
+
+// ExpiringPoints остаток одного начисления и когда он сгорит
+type ExpiringPoints struct {
+	Sum      Money     `json:"sum"`
+	ExpireAt time.Time `json:"expire_at"`
 }
 
 type UserID = uuid.UUID
 
 type WithdrawnRequest struct {
 	OrderID OrderID `json:"order"`
-	Sum     float32 `json:"sum"`
+	Sum     Money   `json:"sum"`
 }
 
 type Withdrawal struct {
-	OrderID    OrderID `json:"order"`
-	Sum        float32 `json:"sum"`
-	CreateTime string  `json:"processed_at"`
+	OrderID    OrderID   `json:"order"`
+	Sum        Money     `json:"sum"`
+	CreateTime time.Time `json:"processed_at"`
+	// списание отменено, баллы вернулись на счет
+	Reversed   bool       `json:"reversed,omitempty"`
+	ReversedAt *time.Time `json:"reversed_at,omitempty"`
 }
 type Withdrawals []Withdrawal
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MoneyScale количество минимальных единиц в одном балле.
+// Храним суммы с точностью до тысячных, чтобы не терять дробные начисления.
+const (
+	MoneyScale  Money = 1000
+	moneyDigits       = 3
+)
+
+var ErrMoneyFormat = errors.New("bad money format")
+var ErrMoneyOverflow = errors.New("money overflow")
+
+// Money денежная сумма в тысячных долях балла.
+// В json представляется обычным числом без потери точности.
+type Money int64
+
+// допускаем только запись числа из json, экспонента ограничена
+// чтобы не раздувать big.Rat
+var moneyRe = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?([eE][+-]?[0-9]{1,3})?$`)
+
+var (
+	bigMoneyScale = big.NewRat(int64(MoneyScale), 1)
+	bigMaxMoney   = new(big.Int).SetInt64(math.MaxInt64)
+	bigMinMoney   = new(big.Int).SetInt64(math.MinInt64)
+)
+
+// ParseMoney разбирает десятичную запись числа (допускается экспонента).
+// Знаки после третьего округляются половиной от нуля.
+func ParseMoney(s string) (Money, error) {
+	if !moneyRe.MatchString(s) {
+		return 0, fmt.Errorf("%w: %q", ErrMoneyFormat, s)
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrMoneyFormat, s)
+	}
+	r.Mul(r, bigMoneyScale)
+
+	// округление половиной от нуля: (|num|*2 + den) / (den*2)
+	num := new(big.Int).Abs(r.Num())
+	den := r.Denom()
+	num.Mul(num, big.NewInt(2))
+	num.Add(num, den)
+	num.Quo(num, new(big.Int).Mul(den, big.NewInt(2)))
+	if r.Sign() < 0 {
+		num.Neg(num)
+	}
+
+	if num.Cmp(bigMaxMoney) > 0 || num.Cmp(bigMinMoney) < 0 {
+		return 0, fmt.Errorf("%w: %q", ErrMoneyOverflow, s)
+	}
+	return Money(num.Int64()), nil
+}
+
+// String возвращает десятичную запись без лишних нулей: 729.98, 500, -0.001
+func (m Money) String() string {
+	var b strings.Builder
+	u := uint64(m)
+	if m < 0 {
+		b.WriteByte('-')
+		u = -u
+	}
+	scale := uint64(MoneyScale)
+	b.WriteString(strconv.FormatUint(u/scale, 10))
+	frac := u % scale
+	if frac != 0 {
+		f := fmt.Sprintf("%0*d", moneyDigits, frac)
+		b.WriteByte('.')
+		b.WriteString(strings.TrimRight(f, "0"))
+	}
+	return b.String()
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	// некоторые клиенты присылают число строкой
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	v, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// MarshalText и UnmarshalText нужны для чтения сумм из флагов и переменных окружения
+func (m Money) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Money) UnmarshalText(data []byte) error {
+	v, err := ParseMoney(string(data))
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
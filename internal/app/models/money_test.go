@@ -0,0 +1,74 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Money
+		err  error
+	}{
+		{"729.98", 729980, nil},
+		{"500", 500000, nil},
+		{"0.001", 1, nil},
+		{"-0.001", -1, nil},
+		{"0.0005", 1, nil},
+		{"-0.0005", -1, nil},
+		{"0.0004", 0, nil},
+		{"1e3", 1000000, nil},
+		{"1.5E-2", 15, nil},
+		{"9223372036854775.807", 9223372036854775807, nil},
+		{"9223372036854775.808", 0, ErrMoneyOverflow},
+		{"1e999", 0, ErrMoneyOverflow},
+		{"", 0, ErrMoneyFormat},
+		{"1.", 0, ErrMoneyFormat},
+		{"+1", 0, ErrMoneyFormat},
+		{"0x10", 0, ErrMoneyFormat},
+		{"1e1000", 0, ErrMoneyFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseMoney(tt.in)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := map[Money]string{
+		0:       "0",
+		729980:  "729.98",
+		500000:  "500",
+		1:       "0.001",
+		-1:      "-0.001",
+		-1500:   "-1.5",
+		1000010: "1000.01",
+	}
+	for m, want := range tests {
+		assert.Equal(t, want, m.String())
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	var req WithdrawnRequest
+	require.NoError(t, json.Unmarshal([]byte(`{"order":"125","sum":0.1}`), &req))
+	assert.Equal(t, Money(100), req.Sum)
+	require.NoError(t, json.Unmarshal([]byte(`{"order":"125","sum":"20.5"}`), &req))
+	assert.Equal(t, Money(20500), req.Sum)
+	assert.Error(t, json.Unmarshal([]byte(`{"order":"125","sum":"abc"}`), &req))
+
+	data, err := json.Marshal(Balance{Current: 729980, Withdrawn: 1})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"current":729.98,"withdrawn":0.001,"expired":0,"held":0}`, string(data))
+}
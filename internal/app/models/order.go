@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"slices"
+	"time"
+)
 
 type OrderStatus string
 
@@ -9,6 +12,8 @@ const (
 	OrderProcessing OrderStatus = "PROCESSING"
 	OrderInvalid    OrderStatus = "INVALID"
 	OrderProcessed  OrderStatus = "PROCESSED"
+	// система расчета так и не ответила финальным статусом
+	OrderStale OrderStatus = "STALE"
 )
 
 type OrderID = string
@@ -16,7 +21,7 @@ type OrderID = string
 type OrderItem struct {
 	OrderID    OrderID     `json:"number"`
 	Status     OrderStatus `json:"status"`
-	Accrual    *float32    `json:"accrual,omitempty"`
+	Accrual    *Money      `json:"accrual,omitempty"`
 	UploadTime time.Time   `json:"uploaded_at"`
 }
 type Orders []OrderItem
@@ -24,6 +29,12 @@ type Orders []OrderItem
 type ProcessingOrderItem struct {
 	OrderID OrderID
 	UserID  UserID
+	// сколько раз уже пытались получить начисление
+	Attempts int
+	// сколько заказ уже в обработке
+	Age time.Duration
+	// W3C traceparent запроса, который поставил заказ в очередь
+	TraceParent string
 }
 
 type ProcessingOrders []ProcessingOrderItem
@@ -46,6 +57,65 @@ type AccrualOrderItem struct {
 	OrderID OrderID            `json:"order"`
 	UserID  UserID             `json:"-"`
 	Status  AccrualOrderStatus `json:"status"`
-	Accrual *float32           `json:"accrual,omitempty"`
+	Accrual *Money             `json:"accrual,omitempty"`
 	Error   error              `json:"-"`
+	// для не финальных статусов и ошибок: новое число попыток
+	// и через сколько пробовать снова
+	Attempts      int           `json:"-"`
+	NextAttemptIn time.Duration `json:"-"`
+	// сколько попыток было, когда заказ взяли в обработку
+	ClaimedAttempts int `json:"-"`
+	// попытки исчерпаны, заказ уходит в orders_dead_letter
+	Dead bool `json:"-"`
+	// W3C traceparent спана обработки заказа
+	TraceParent string `json:"-"`
+	// через сколько сгорят начисленные баллы, 0 - не сгорают
+	PointsTTL time.Duration `json:"-"`
+}
+
+// Terminated заказ получил финальный статус и больше не обрабатывается
+func (item *AccrualOrderItem) Terminated() bool {
+	return item.Error == nil && slices.Contains(AccrualOrderTerminateStatus, item.Status)
+}
+
+// FailReason почему заказ еще не обработан
+func (item *AccrualOrderItem) FailReason() string {
+	if item.Error != nil {
+		return item.Error.Error()
+	}
+	return "accrual status " + string(item.Status)
+}
+
+// OrderStatus статус заказа для пользователя
+func (s AccrualOrderStatus) OrderStatus() OrderStatus {
+	switch s {
+	case AccrualOrderRegistered, AccrualOrderProcessing:
+		return OrderProcessing
+	case AccrualOrderInvalid:
+		return OrderInvalid
+	case AccrualOrderProcessed:
+		return OrderProcessed
+	}
+	return OrderNew
+}
+
+// DeadLetter заказ, для которого не дождались финального статуса
+type DeadLetter struct {
+	OrderID   OrderID   `json:"number"`
+	UserID    UserID    `json:"user_id"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error"`
+	CreatedAt time.Time `json:"created_at"`
+	DeadAt    time.Time `json:"dead_at"`
+}
+type DeadLetters []DeadLetter
+
+// QueueStats состояние очереди orders_for_process
+type QueueStats struct {
+	// всего заказов в очереди
+	Total int
+	// готовы к обработке: не заблокированы и время попытки подошло
+	Ready int
+	// who -> сколько заказов заблокировал
+	Locked map[string]int
 }
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrBadCursor = errors.New("bad cursor")
+
+// Cursor позиция в списке, отсортированном по (Time, ID) по убыванию.
+// Следующая страница начинается строго после нее
+type Cursor struct {
+	Time time.Time
+	ID   string
+}
+
+// String непрозрачная для клиента строка.
+// Время с точностью до микросекунды, как в бд, иначе курсор не совпадет с записью
+func (c Cursor) String() string {
+	raw := strconv.FormatInt(c.Time.Truncate(time.Microsecond).UnixNano(), 10) + "." + c.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func ParseCursor(s string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+	ts, id, ok := strings.Cut(string(raw), ".")
+	if !ok || id == "" {
+		return nil, ErrBadCursor
+	}
+	nano, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+	return &Cursor{Time: time.Unix(0, nano).UTC(), ID: id}, nil
+}
+
+// ListFilter параметры списка заказов или списаний.
+// Нулевое значение - весь список целиком
+type ListFilter struct {
+	// 0 - без ограничения
+	Limit uint
+	After *Cursor
+	// только для заказов, пустой - любой статус
+	Statuses []OrderStatus
+	// [From, To), нулевое время - без границы
+	From time.Time
+	To   time.Time
+}
+
+// Match для хранилищ, которые фильтруют в памяти
+func (f *ListFilter) Match(t time.Time, id string, status OrderStatus) bool {
+	if !f.From.IsZero() && t.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !t.Before(f.To) {
+		return false
+	}
+	if len(f.Statuses) != 0 {
+		found := false
+		for _, s := range f.Statuses {
+			if s == status {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.After != nil {
+		// строго после курсора в порядке убывания
+		if t.After(f.After.Time) || (t.Equal(f.After.Time) && id >= f.After.ID) {
+			return false
+		}
+	}
+	return true
+}
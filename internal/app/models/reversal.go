@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+type ReversalRequest struct {
+	Reason string `json:"reason"`
+}
+
+// Reversal отмена списания баллов за заказ, например если магазин отменил заказ
+type Reversal struct {
+	OrderID OrderID `json:"order"`
+	UserID  UserID  `json:"user_id"`
+	Sum     Money   `json:"sum"`
+	// администратор или магазин, отменивший списание
+	ReversedBy string    `json:"reversed_by"`
+	Reason     string    `json:"reason,omitempty"`
+	CreatedAt  time.Time `json:"reversed_at"`
+}
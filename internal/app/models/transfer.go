@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type TransferRequest struct {
+	// логин получателя
+	To  string `json:"to"`
+	Sum Money  `json:"sum"`
+}
+
+type TransferDirection string
+
+const (
+	TransferSent     TransferDirection = "out"
+	TransferReceived TransferDirection = "in"
+)
+
+// Transfer перевод баллов с точки зрения одной из сторон
+type Transfer struct {
+	ID        uuid.UUID         `json:"id"`
+	Direction TransferDirection `json:"direction"`
+	// логин второй стороны
+	Counterparty string    `json:"counterparty"`
+	Sum          Money     `json:"sum"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+type Transfers []Transfer
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// Webhook адрес, куда пользователь получает уведомления о заказах
+type Webhook struct {
+	UserID UserID `json:"-"`
+	URL    string `json:"url"`
+	// ключ подписи отдаем только при создании
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookPending   WebhookDeliveryStatus = "PENDING"
+	WebhookDelivered WebhookDeliveryStatus = "DELIVERED"
+	// попытки исчерпаны или вебхук удален
+	WebhookFailed WebhookDeliveryStatus = "FAILED"
+)
+
+// WebhookDelivery запись outbox: событие и состояние его доставки
+type WebhookDelivery struct {
+	ID             int64                 `json:"id"`
+	UserID         UserID                `json:"-"`
+	Event          EventType             `json:"event"`
+	Payload        json.RawMessage       `json:"payload"`
+	Status         WebhookDeliveryStatus `json:"status"`
+	Attempts       int                   `json:"attempts"`
+	LastStatusCode int                   `json:"last_status_code,omitempty"`
+	LastError      string                `json:"last_error,omitempty"`
+	CreatedAt      time.Time             `json:"created_at"`
+	DeliveredAt    *time.Time            `json:"delivered_at,omitempty"`
+	// куда и с каким ключом отправлять, заполняется при захвате на отправку
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
+type WebhookDeliveries []WebhookDelivery
+
+// Cursor позиция записи в журнале доставки
+func (d *WebhookDelivery) Cursor() Cursor {
+	return Cursor{Time: d.CreatedAt, ID: strconv.FormatInt(d.ID, 10)}
+}
+
+// WebhookResult итог одной попытки доставки
+type WebhookResult struct {
+	ID         int64
+	StatusCode int
+	Error      string
+	// новое число попыток
+	Attempts  int
+	Delivered bool
+	// попытки исчерпаны
+	Failed        bool
+	NextAttemptIn time.Duration
+}
@@ -0,0 +1,112 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+)
+
+const (
+	pageMaxLimit = 1000
+	// курсор следующей страницы. Тело ответа остается массивом, как без пагинации
+	nextCursorHeader = "X-Next-Cursor"
+)
+
+var knownOrderStatuses = []models.OrderStatus{
+	models.OrderNew,
+	models.OrderProcessing,
+	models.OrderInvalid,
+	models.OrderProcessed,
+	models.OrderStale,
+}
+
+// parseListFilter разбирает limit, cursor, status, from и to.
+// Без параметров возвращает пустой фильтр - весь список, как раньше
+func parseListFilter(r *http.Request, withStatus bool) (*models.ListFilter, error) {
+	q := r.URL.Query()
+	filter := &models.ListFilter{}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.ParseUint(v, 10, 32)
+		if err != nil || limit == 0 || limit > pageMaxLimit {
+			return nil, fmt.Errorf("limit must be from 1 to %d", pageMaxLimit)
+		}
+		filter.Limit = uint(limit)
+	}
+	if v := q.Get("cursor"); v != "" {
+		cursor, err := models.ParseCursor(v)
+		if err != nil {
+			return nil, err
+		}
+		filter.After = cursor
+	}
+	if v := q.Get("status"); v != "" {
+		if !withStatus {
+			return nil, errors.New("status filter is not supported")
+		}
+		for _, s := range strings.Split(v, ",") {
+			status := models.OrderStatus(strings.ToUpper(strings.TrimSpace(s)))
+			if !isKnownOrderStatus(status) {
+				return nil, fmt.Errorf("unknown status %q", s)
+			}
+			filter.Statuses = append(filter.Statuses, status)
+		}
+	}
+	var err error
+	if filter.From, err = parseTimeParam(q.Get("from")); err != nil {
+		return nil, fmt.Errorf("bad from: %w", err)
+	}
+	if filter.To, err = parseTimeParam(q.Get("to")); err != nil {
+		return nil, fmt.Errorf("bad to: %w", err)
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return nil, errors.New("from must be before to")
+	}
+	return filter, nil
+}
+
+func isKnownOrderStatus(status models.OrderStatus) bool {
+	for _, s := range knownOrderStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTimeParam RFC3339, пустая строка - без границы.
+// В бд время хранится в UTC
+func parseTimeParam(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.UTC(), nil
+}
+
+// fetchLimit просим у хранилища на одну запись больше, чтобы узнать есть ли следующая страница
+func fetchLimit(filter *models.ListFilter) *models.ListFilter {
+	f := *filter
+	if f.Limit > 0 {
+		f.Limit++
+	}
+	return &f
+}
+
+// setNextCursor обрезает лишнюю запись и отдает курсор следующей страницы в заголовке
+func setNextCursor[T any](w http.ResponseWriter, items []T, filter *models.ListFilter, cursor func(T) models.Cursor) []T {
+	if filter.Limit == 0 || uint(len(items)) <= filter.Limit {
+		return items
+	}
+	items = items[:filter.Limit]
+	w.Header().Set(nextCursorHeader, cursor(items[len(items)-1]).String())
+	return items
+}
@@ -0,0 +1,148 @@
+// Package problem ошибки API в формате application/problem+json (RFC 7807)
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/serg2014/go-musthave-diploma/internal/app/storage"
+	"github.com/serg2014/go-musthave-diploma/internal/logger"
+	"go.uber.org/zap"
+)
+
+const ContentType = "application/problem+json"
+
+// префикс type, по нему и code клиенты различают ошибки
+const typePrefix = "urn:gophermart:problem:"
+
+// Стабильные коды ошибок. Меняться не должны: на них завязаны клиенты
+const (
+	CodeBadRequest            = "bad_request"
+	CodeBadJSON               = "bad_json"
+	CodeEmptyCredentials      = "empty_credentials"
+	CodeUserExists            = "user_exists"
+	CodeInvalidCredentials    = "invalid_credentials"
+	CodeUnauthorized          = "unauthorized"
+	CodeForbidden             = "forbidden"
+	CodeInvalidRefreshToken   = "invalid_refresh_token"
+	CodeInvalidOrderNumber    = "invalid_order_number"
+	CodeOrderOwnedByOtherUser = "order_owned_by_other_user"
+	CodeInvalidSum            = "invalid_sum"
+	CodeNotEnoughPoints       = "not_enough_points"
+	CodeWithdrawalExists      = "withdrawal_exists"
+	CodeWithdrawalReversed    = "withdrawal_already_reversed"
+	CodeHoldExists            = "hold_exists"
+	CodeHoldFinished          = "hold_already_finished"
+	CodeHoldExpired           = "hold_expired"
+	CodeRecipientNotFound     = "recipient_not_found"
+	CodeSelfTransfer          = "self_transfer"
+	CodeTransferLimit         = "transfer_limit_exceeded"
+	CodeInvalidQuery          = "invalid_query"
+	CodeInvalidWebhookURL     = "invalid_webhook_url"
+	CodeNotFound              = "not_found"
+	CodeMethodNotAllowed      = "method_not_allowed"
+	CodeIdempotencyKeyMissing = "idempotency_key_required"
+	CodeIdempotencyKeyReused  = "idempotency_key_reused"
+	CodeIdempotencyInProgress = "idempotency_in_progress"
+	CodePayloadTooLarge       = "payload_too_large"
+	CodeInternal              = "internal_error"
+)
+
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Code      string `json:"code"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+func New(status int, code, detail string) *Problem {
+	return &Problem{
+		Type:   typePrefix + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Code:   code,
+		Detail: detail,
+	}
+}
+
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Code + ": " + p.Detail
+	}
+	return p.Code
+}
+
+// storageProblems единое соответствие ошибок хранилища ответам API
+var storageProblems = []struct {
+	err     error
+	problem *Problem
+}{
+	{storage.ErrUserExists, New(http.StatusConflict, CodeUserExists, "login is already taken")},
+	{storage.ErrUserOrPassword, New(http.StatusUnauthorized, CodeInvalidCredentials, "bad login or password")},
+	{storage.ErrOrderAnotherUser, New(http.StatusConflict, CodeOrderOwnedByOtherUser, "order was uploaded by another user")},
+	{storage.ErrNotEnoughMoney, New(http.StatusPaymentRequired, CodeNotEnoughPoints, "not enough points")},
+	{storage.ErrOrderWithdrawnExists, New(http.StatusUnprocessableEntity, CodeWithdrawalExists, "withdrawal for this order already exists")},
+	{storage.ErrWithdrawalNotFound, New(http.StatusNotFound, CodeNotFound, "withdrawal not found")},
+	{storage.ErrWithdrawalReversed, New(http.StatusConflict, CodeWithdrawalReversed, "withdrawal is already reversed")},
+	{storage.ErrHoldNotFound, New(http.StatusNotFound, CodeNotFound, "hold not found")},
+	{storage.ErrHoldExists, New(http.StatusConflict, CodeHoldExists, "points for this order are already held")},
+	{storage.ErrHoldFinished, New(http.StatusConflict, CodeHoldFinished, "hold is already captured or released")},
+	{storage.ErrHoldExpired, New(http.StatusConflict, CodeHoldExpired, "hold expired")},
+	{storage.ErrRecipientNotFound, New(http.StatusUnprocessableEntity, CodeRecipientNotFound, "recipient not found")},
+	{storage.ErrSelfTransfer, New(http.StatusUnprocessableEntity, CodeSelfTransfer, "cannot transfer points to yourself")},
+	{storage.ErrTransferLimitExceeded, New(http.StatusUnprocessableEntity, CodeTransferLimit, "daily transfer limit exceeded")},
+	{storage.ErrSessionNotFound, New(http.StatusUnauthorized, CodeUnauthorized, "")},
+	{storage.ErrDeadLetterNotFound, New(http.StatusNotFound, CodeNotFound, "order is not in dead letter queue")},
+	{storage.ErrWebhookNotFound, New(http.StatusNotFound, CodeNotFound, "webhook is not set")},
+	{storage.ErrIdempotencyKeyReused, New(http.StatusConflict, CodeIdempotencyKeyReused, "idempotency key was used with another request")},
+	{storage.ErrIdempotencyInProgress, New(http.StatusConflict, CodeIdempotencyInProgress, "request with this idempotency key is in progress")},
+}
+
+// FromError ошибка хранилища или Problem. Остальное - внутренняя ошибка,
+// подробности клиенту не отдаем
+func FromError(err error) *Problem {
+	var p *Problem
+	if errors.As(err, &p) {
+		return p
+	}
+	for _, item := range storageProblems {
+		if errors.Is(err, item.err) {
+			copied := *item.problem
+			return &copied
+		}
+	}
+	return New(http.StatusInternalServerError, CodeInternal, "")
+}
+
+// Write отдает ошибку клиенту, добавляя request id
+func Write(w http.ResponseWriter, r *http.Request, p *Problem) {
+	resp := *p
+	resp.Instance = r.URL.Path
+	resp.RequestID = middleware.GetReqID(r.Context())
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(resp.Status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Log.Error("error encoding problem", zap.Error(err))
+	}
+}
+
+// Error пишет ошибку по err, внутренние ошибки логирует
+func Error(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	p := FromError(err)
+	if p.Status >= http.StatusInternalServerError {
+		logger.Log.Error(msg, zap.Error(err), zap.String("request_id", middleware.GetReqID(r.Context())))
+	}
+	Write(w, r, p)
+}
+
+// Status ошибка без подробностей, например 401 в middleware
+func Status(w http.ResponseWriter, r *http.Request, status int, code string) {
+	Write(w, r, New(status, code, ""))
+}
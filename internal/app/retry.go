@@ -0,0 +1,80 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/serg2014/go-musthave-diploma/internal/app/accrual"
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+)
+
+// errSaveResult результат не удалось сохранить в хранилище
+var errSaveResult = errors.New("failed save result")
+
+// backoff экспоненциальная задержка base*2^attempts, не больше maxDelay.
+// Джиттер: случайное значение из [d/2, d], чтобы заказы не приходили пачкой
+func backoff(attempts int, base, maxDelay time.Duration) time.Duration {
+	d := base
+	for i := 0; i < attempts && d < maxDelay; i++ {
+		d *= 2
+	}
+	if d > maxDelay {
+		d = maxDelay
+	}
+	half := d / 2
+	if half <= 0 {
+		return d
+	}
+	return half + rand.N(half+1)
+}
+
+// notOrderFault ошибки не связанные с самим заказом, попытку не засчитываем:
+// accrual недоступен (5xx, таймаут, сеть), ограничивает нас (429), мы останавливаемся
+// или не смогли сохранить результат
+func notOrderFault(err error) bool {
+	return accrual.IsFailure(err) ||
+		errors.Is(err, errSaveResult) ||
+		errors.Is(err, accrual.ErrHTTPNTooManyRequets) ||
+		errors.Is(err, accrual.ErrCircuitOpen) ||
+		errors.Is(err, accrual.ErrDoneContext) ||
+		errors.Is(err, accrual.ErrContext)
+}
+
+// scheduleRetry для не финальных статусов и ошибок считает следующую попытку
+func (a *App) scheduleRetry(item *models.ProcessingOrderItem, res *models.AccrualOrderItem) {
+	res.ClaimedAttempts = item.Attempts
+	res.Attempts = item.Attempts
+	if res.Terminated() {
+		return
+	}
+	if res.Error != nil && notOrderFault(res.Error) {
+		res.NextAttemptIn = backoff(res.Attempts, a.config.RetryBaseDelay, a.config.RetryMaxDelay)
+		return
+	}
+	res.Attempts++
+	if res.Attempts >= a.config.OrderMaxAttempts || item.Age >= a.config.OrderMaxAge {
+		res.Dead = true
+		return
+	}
+	res.NextAttemptIn = backoff(res.Attempts, a.config.RetryBaseDelay, a.config.RetryMaxDelay)
+}
+
+// saveFailed результат, который не удалось сохранить, превращаем в ошибку:
+// заказ отложится с числом попыток до этого раунда, сбой хранилища попыткой не считается
+func (a *App) saveFailed(res *models.AccrualOrderItem, err error) *models.AccrualOrderItem {
+	failed := &models.AccrualOrderItem{
+		OrderID:     res.OrderID,
+		UserID:      res.UserID,
+		Error:       fmt.Errorf("%w: %w", errSaveResult, err),
+		TraceParent: res.TraceParent,
+	}
+	item := &models.ProcessingOrderItem{
+		OrderID:  res.OrderID,
+		UserID:   res.UserID,
+		Attempts: res.ClaimedAttempts,
+	}
+	a.scheduleRetry(item, failed)
+	return failed
+}
@@ -0,0 +1,44 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/serg2014/go-musthave-diploma/internal/app/accrual"
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduleRetry(t *testing.T) {
+	a := newTestApp(t, newFakeAccrual())
+	tests := []struct {
+		name     string
+		err      error
+		before   int
+		attempts int
+		dead     bool
+	}{
+		{"not registered", accrual.ErrHTTPNoContent, 1, 2, false},
+		{"last attempt", accrual.ErrHTTPOther, 2, 3, true},
+		{"too many requests", &accrual.TooManyRequestsError{RetryAfter: time.Second}, 1, 1, false},
+		{"server error", accrual.ErrHTTPInternalServerError, 1, 1, false},
+		{"timeout", accrual.ErrTimeout, 1, 1, false},
+		{"circuit open", accrual.ErrCircuitOpen, 1, 1, false},
+		{"stopped", accrual.ErrDoneContext, 1, 1, false},
+		{"save failed", fmt.Errorf("%w: %w", errSaveResult, errors.New("db is down")), 1, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := &models.ProcessingOrderItem{OrderID: "125", Attempts: tt.before}
+			res := &models.AccrualOrderItem{OrderID: "125", Error: tt.err}
+			a.scheduleRetry(item, res)
+			assert.Equal(t, tt.attempts, res.Attempts)
+			assert.Equal(t, tt.dead, res.Dead)
+			if !tt.dead {
+				assert.Positive(t, res.NextAttemptIn)
+			}
+		})
+	}
+}
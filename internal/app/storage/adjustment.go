@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+)
+
+// AdjustBalance исправляет баланс пользователя adj.UserID на adj.Sum.
+// Начисление - новая партия баллов со сроком pointsTTL, как у начислений за заказы,
+// списание берет баллы из партий от старых к новым. Заполняет ID и CreatedAt
+func (s *storage) AdjustBalance(ctx context.Context, adj *models.Adjustment, pointsTTL time.Duration) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockBalance(ctx, tx, adj.UserID); err != nil {
+		return err
+	}
+	var (
+		remaining sql.NullInt64
+		ttl       float64
+	)
+	if adj.Sum < 0 {
+		available, err := availablePoints(ctx, tx, adj.UserID)
+		if err != nil {
+			return err
+		}
+		if available < -adj.Sum {
+			return ErrNotEnoughMoney
+		}
+		if _, err := consumeLots(ctx, tx, adj.UserID, -adj.Sum); err != nil {
+			return err
+		}
+	} else {
+		remaining = sql.NullInt64{Int64: int64(adj.Sum), Valid: true}
+		ttl = pointsTTL.Seconds()
+	}
+
+	adj.ID = uuid.New()
+	query := `
+		INSERT INTO balance_adjustments (id, user_id, sum, reason, admin)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING created_at`
+	err = tx.QueryRowContext(ctx, query, adj.ID, adj.UserID, adj.Sum, adj.Reason, adj.Admin).Scan(&adj.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed insert balance_adjustments: %w", err)
+	}
+	query = `
+		INSERT INTO debet_credit (order_id, type, user_id, sum, remaining, expire_at)
+		VALUES ($1, $2, $3, $4, $5,
+		  CASE WHEN $6 > 0 THEN current_timestamp + make_interval(secs => $6) END)`
+	_, err = tx.ExecContext(ctx, query, adj.ID.String(), models.CreditAdjustment, adj.UserID, adj.Sum, remaining, ttl)
+	if err != nil {
+		return fmt.Errorf("failed insert debet_credit: %w", err)
+	}
+	if err := publishEvents(ctx, tx, adj.UserID, []models.Event{models.AdjustmentEvent(adj)}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed commit AdjustBalance: %w", err)
+	}
+	return nil
+}
+
+// Adjustments корректировки баланса пользователя вместе с администратором
+func (s *storage) Adjustments(ctx context.Context, userID models.UserID, filter *models.ListFilter) (models.Adjustments, error) {
+	query, args := listQuery(`
+		SELECT id, sum, reason, admin, created_at
+		FROM balance_adjustments
+		WHERE user_id = $1`,
+		[]any{userID}, filter, "created_at", "id::text", "",
+	)
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed Adjustments: %w", err)
+	}
+	defer rows.Close()
+
+	result := make(models.Adjustments, 0, 10)
+	for rows.Next() {
+		item := models.Adjustment{UserID: userID}
+		if err := rows.Scan(&item.ID, &item.Sum, &item.Reason, &item.Admin, &item.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed Scan in Adjustments: %w", err)
+		}
+		result = append(result, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed Adjustments: %w", err)
+	}
+	return result, nil
+}
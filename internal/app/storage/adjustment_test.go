@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemAdjustBalance(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStorage()
+	userID, err := s.CreateUser(ctx, "user", "hash")
+	require.NoError(t, err)
+	balance := func() *models.Balance {
+		b, err := s.Balance(ctx, *userID, 2*time.Hour)
+		require.NoError(t, err)
+		return b
+	}
+
+	add := &models.Adjustment{UserID: *userID, Sum: 100 * models.MoneyScale, Reason: "bonus", Admin: "root"}
+	require.NoError(t, s.AdjustBalance(ctx, add, time.Hour))
+	assert.NotZero(t, add.ID)
+	b := balance()
+	assert.Equal(t, 100*models.MoneyScale, b.Current)
+	// начисление сгорает как обычные баллы
+	require.Len(t, b.Expiring, 1)
+	assert.Equal(t, 100*models.MoneyScale, b.Expiring[0].Sum)
+
+	err = s.AdjustBalance(ctx, &models.Adjustment{UserID: *userID, Sum: -101 * models.MoneyScale, Reason: "fraud", Admin: "root"}, time.Hour)
+	assert.ErrorIs(t, err, ErrNotEnoughMoney)
+	require.NoError(t, s.AdjustBalance(ctx, &models.Adjustment{UserID: *userID, Sum: -40 * models.MoneyScale, Reason: "fraud", Admin: "root"}, time.Hour))
+	b = balance()
+	// списание корректировкой не считается выводом
+	assert.Equal(t, 60*models.MoneyScale, b.Current)
+	assert.Equal(t, models.Money(0), b.Withdrawn)
+
+	require.NoError(t, s.Withdraw(ctx, *userID, "12345678903", 60*models.MoneyScale))
+	assert.Equal(t, models.Money(0), balance().Current)
+
+	history, err := s.Adjustments(ctx, *userID, &models.ListFilter{})
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, -40*models.MoneyScale, history[0].Sum)
+	assert.Equal(t, "fraud", history[0].Reason)
+	assert.Equal(t, 100*models.MoneyScale, history[1].Sum)
+	assert.Equal(t, "root", history[1].Admin)
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+)
+
+// publishEvents пишет события в журнал пользователя и outbox вебхука
+// в той же транзакции, что и само изменение. Подписчики узнают о них
+// через NOTIFY после коммита
+func publishEvents(ctx context.Context, tx *sql.Tx, userID models.UserID, events []models.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	for _, event := range events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("failed marshal event: %w", err)
+		}
+		query := "INSERT INTO user_events (user_id, type, payload) VALUES ($1, $2, $3::jsonb)"
+		_, err = tx.ExecContext(ctx, query, userID, event.Type, string(payload))
+		if err != nil {
+			return fmt.Errorf("failed insert user_events: %w", err)
+		}
+		// без вебхука у пользователя в outbox ничего не пишем
+		query = `
+		INSERT INTO webhook_outbox (user_id, event, payload)
+		SELECT $1, $2, $3::jsonb
+		WHERE EXISTS (SELECT 1 FROM webhooks WHERE user_id = $1)`
+		_, err = tx.ExecContext(ctx, query, userID, event.Type, string(payload))
+		if err != nil {
+			return fmt.Errorf("failed insert webhook_outbox: %w", err)
+		}
+	}
+	// одинаковые уведомления в транзакции postgres схлопывает сам
+	_, err := tx.ExecContext(ctx, "SELECT pg_notify($1, $2)", UserEventsChannel, userID.String())
+	if err != nil {
+		return fmt.Errorf("failed notify user events: %w", err)
+	}
+	return nil
+}
+
+// UserEvents события пользователя с id больше afterID по возрастанию
+func (s *storage) UserEvents(ctx context.Context, userID models.UserID, afterID int64, limit uint) (models.UserEvents, error) {
+	query := `
+		SELECT id, type, payload, created_at
+		FROM user_events
+		WHERE user_id = $1 AND id > $2
+		ORDER BY id
+		LIMIT $3`
+	rows, err := s.db.QueryContext(ctx, query, userID, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed UserEvents: %w", err)
+	}
+	defer rows.Close()
+
+	result := make(models.UserEvents, 0, 10)
+	for rows.Next() {
+		var (
+			item    models.UserEvent
+			payload []byte
+		)
+		if err := rows.Scan(&item.ID, &item.Type, &payload, &item.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed Scan in UserEvents: %w", err)
+		}
+		item.UserID = userID
+		item.Data = json.RawMessage(payload)
+		result = append(result, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed UserEvents: %w", err)
+	}
+	return result, nil
+}
+
+// LastUserEventID id последнего события, 0 если событий нет
+func (s *storage) LastUserEventID(ctx context.Context, userID models.UserID) (int64, error) {
+	query := "SELECT coalesce(max(id), 0) FROM user_events WHERE user_id = $1"
+	var id int64
+	if err := s.db.QueryRowContext(ctx, query, userID).Scan(&id); err != nil {
+		return 0, fmt.Errorf("failed LastUserEventID: %w", err)
+	}
+	return id, nil
+}
+
+// DeleteExpiredUserEvents журнал нужен только для переподключения, старые события удаляем
+func (s *storage) DeleteExpiredUserEvents(ctx context.Context, ttl time.Duration) error {
+	query := "DELETE FROM user_events WHERE created_at <= current_timestamp - make_interval(secs => $1)"
+	_, err := s.db.ExecContext(ctx, query, ttl.Seconds())
+	if err != nil {
+		return fmt.Errorf("failed delete expired user events: %w", err)
+	}
+	return nil
+}
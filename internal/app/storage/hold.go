@@ -0,0 +1,264 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+)
+
+var ErrHoldNotFound = errors.New("hold not found")
+var ErrHoldExists = errors.New("hold for order exists")
+var ErrHoldFinished = errors.New("hold already captured or released")
+var ErrHoldExpired = errors.New("hold expired")
+
+// checkNoHold у заказа нет действующего или подтвержденного резерва. Снятый резерв не мешает
+func checkNoHold(ctx context.Context, tx *sql.Tx, orderID models.OrderID) error {
+	var exists bool
+	query := "SELECT EXISTS (SELECT 1 FROM holds WHERE order_id = $1 AND status IN ('HELD', 'CAPTURED'))"
+	err := tx.QueryRowContext(ctx, query, orderID).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed select holds: %w", err)
+	}
+	if exists {
+		return ErrHoldExists
+	}
+	return nil
+}
+
+// HoldPoints резервирует sum баллов под заказ на время ttl.
+// Баллы сразу списываются с партий записью HOLD и недоступны для других списаний
+func (s *storage) HoldPoints(ctx context.Context, userID models.UserID, orderID models.OrderID, sum models.Money, ttl time.Duration) (*models.Hold, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockBalance(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+	var withdrawn bool
+	query := `SELECT EXISTS (SELECT 1 FROM debet_credit WHERE order_id = $1 AND "type" = 'CREDIT')`
+	if err := tx.QueryRowContext(ctx, query, orderID).Scan(&withdrawn); err != nil {
+		return nil, fmt.Errorf("failed select debet_credit: %w", err)
+	}
+	if withdrawn {
+		return nil, ErrOrderWithdrawnExists
+	}
+	available, err := availablePoints(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if available < sum {
+		return nil, ErrNotEnoughMoney
+	}
+
+	hold := models.Hold{
+		OrderID: orderID,
+		UserID:  userID,
+		Sum:     sum,
+		Status:  models.HoldHeld,
+	}
+	// снятый резерв заменяем новым: оплата не прошла, пользователь пробует снова
+	query = `
+		INSERT INTO holds (order_id, user_id, sum, expires_at)
+		VALUES ($1, $2, $3, current_timestamp + make_interval(secs => $4))
+		ON CONFLICT (order_id) DO UPDATE SET
+		id = gen_random_uuid(),
+		user_id = EXCLUDED.user_id,
+		sum = EXCLUDED.sum,
+		status = 'HELD',
+		expires_at = EXCLUDED.expires_at,
+		created_at = current_timestamp,
+		finished_at = NULL
+		WHERE holds.status = 'RELEASED'
+		RETURNING id, expires_at, created_at`
+	err = tx.QueryRowContext(ctx, query, orderID, userID, sum, ttl.Seconds()).Scan(&hold.ID, &hold.ExpiresAt, &hold.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrHoldExists
+		}
+		return nil, fmt.Errorf("failed insert holds: %w", err)
+	}
+	expireAt, err := consumeLots(ctx, tx, userID, sum)
+	if err != nil {
+		return nil, err
+	}
+	// срок сгорания вернется с баллами при снятии резерва
+	query = `
+		INSERT INTO debet_credit (order_id, type, user_id, sum, expire_at)
+		VALUES ($1, $2, $3, $4, $5)`
+	_, err = tx.ExecContext(ctx, query, hold.ID.String(), models.CreditHold, userID, sum, expireAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed insert debet_credit: %w", err)
+	}
+	err = publishEvents(ctx, tx, userID, []models.Event{models.HoldEvent(orderID, sum)})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed commit HoldPoints: %w", err)
+	}
+	return &hold, nil
+}
+
+// CaptureHold подтверждает резерв: баллы списываются за заказ записью CREDIT,
+// как обычное списание
+func (s *storage) CaptureHold(ctx context.Context, orderID models.OrderID) (*models.Hold, error) {
+	return s.finishHold(ctx, orderID, models.HoldCaptured)
+}
+
+// ReleaseHold снимает резерв: баллы возвращаются записью RELEASE
+func (s *storage) ReleaseHold(ctx context.Context, orderID models.OrderID) (*models.Hold, error) {
+	return s.finishHold(ctx, orderID, models.HoldReleased)
+}
+
+func (s *storage) finishHold(ctx context.Context, orderID models.OrderID, status models.HoldStatus) (*models.Hold, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	hold, err := finishHold(ctx, tx, orderID, status)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed commit finish hold: %w", err)
+	}
+	return hold, nil
+}
+
+// finishHold переводит резерв из HELD в status и пишет CREDIT или RELEASE
+func finishHold(ctx context.Context, tx *sql.Tx, orderID models.OrderID, status models.HoldStatus) (*models.Hold, error) {
+	query := `
+		SELECT h.id, h.user_id, h.sum, h.status, h.expires_at, h.created_at, d.expire_at,
+		  h.expires_at <= current_timestamp
+		FROM holds AS h
+		JOIN debet_credit AS d ON d.order_id = h.id::text AND d."type" = 'HOLD'
+		WHERE h.order_id = $1
+		FOR UPDATE OF h`
+	hold := models.Hold{OrderID: orderID}
+	var (
+		expireAt sql.NullTime
+		stale    bool
+	)
+	err := tx.QueryRowContext(ctx, query, orderID).Scan(&hold.ID, &hold.UserID, &hold.Sum, &hold.Status,
+		&hold.ExpiresAt, &hold.CreatedAt, &expireAt, &stale)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrHoldNotFound
+		}
+		return nil, fmt.Errorf("failed select hold: %w", err)
+	}
+	if hold.Status != models.HoldHeld {
+		return nil, ErrHoldFinished
+	}
+	// просроченный резерв можно только снять, даже если фоновая задача до него не дошла
+	if stale && status == models.HoldCaptured {
+		return nil, ErrHoldExpired
+	}
+
+	var (
+		entry models.DebetCreditType
+		event models.Event
+		// списание - за заказ, возврат - под id резерва, как и HOLD
+		entryID = orderID
+	)
+	switch status {
+	case models.HoldCaptured:
+		// у списания, как в Withdraw, срок сгорания списанных баллов
+		query = `
+			INSERT INTO debet_credit (order_id, type, user_id, sum, expire_at)
+			VALUES ($1, $2, $3, $4, $5)`
+		entry = models.Credit
+		event = models.WithdrawEvent(orderID, hold.Sum)
+	case models.HoldReleased:
+		query = `
+			INSERT INTO debet_credit (order_id, type, user_id, sum, remaining, expire_at)
+			VALUES ($1, $2, $3, $4, $4, $5)`
+		entry = models.CreditRelease
+		event = models.ReleaseEvent(orderID, hold.Sum)
+		entryID = hold.ID.String()
+	default:
+		return nil, fmt.Errorf("bad hold status %q", status)
+	}
+	_, err = tx.ExecContext(ctx, query, entryID, entry, hold.UserID, hold.Sum, expireAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation && status == models.HoldCaptured {
+			return nil, ErrOrderWithdrawnExists
+		}
+		return nil, fmt.Errorf("failed insert debet_credit: %w", err)
+	}
+
+	query = `
+		UPDATE holds SET status = $2, finished_at = current_timestamp
+		WHERE order_id = $1
+		RETURNING finished_at`
+	var finishedAt time.Time
+	if err := tx.QueryRowContext(ctx, query, orderID, status).Scan(&finishedAt); err != nil {
+		return nil, fmt.Errorf("failed update holds: %w", err)
+	}
+	hold.Status = status
+	hold.FinishedAt = &finishedAt
+
+	if err := publishEvents(ctx, tx, hold.UserID, []models.Event{event}); err != nil {
+		return nil, err
+	}
+	return &hold, nil
+}
+
+// ReleaseStaleHolds снимает не больше limit резервов, которые никто не подтвердил вовремя.
+// Возвращает сколько снято
+func (s *storage) ReleaseStaleHolds(ctx context.Context, limit uint) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed transaction in ReleaseStaleHolds: %w", err)
+	}
+	defer tx.Rollback()
+
+	// резервы, которые сейчас подтверждают, пропускаем до следующего раза
+	query := `
+		SELECT order_id FROM holds
+		WHERE status = 'HELD' AND expires_at <= current_timestamp
+		ORDER BY expires_at
+		FOR UPDATE SKIP LOCKED
+		LIMIT $1`
+	rows, err := tx.QueryContext(ctx, query, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed select stale holds: %w", err)
+	}
+	defer rows.Close()
+
+	orders := make([]models.OrderID, 0, limit)
+	for rows.Next() {
+		var orderID models.OrderID
+		if err := rows.Scan(&orderID); err != nil {
+			return 0, fmt.Errorf("failed scan stale holds: %w", err)
+		}
+		orders = append(orders, orderID)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed select stale holds: %w", err)
+	}
+	rows.Close()
+
+	for _, orderID := range orders {
+		if _, err := finishHold(ctx, tx, orderID, models.HoldReleased); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed commit ReleaseStaleHolds: %w", err)
+	}
+	return len(orders), nil
+}
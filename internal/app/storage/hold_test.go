@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+	"github.com/serg2014/go-musthave-diploma/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testDSNEnv бд для тестов Postgres хранилища. Без нее проверяем только память
+const testDSNEnv = "TEST_DATABASE_URI"
+
+// testStorages хранилища, на которых тест должен вести себя одинаково
+func testStorages(t *testing.T) map[string]Storager {
+	t.Helper()
+	stores := map[string]Storager{"memory": NewMemStorage()}
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		t.Logf("%s is not set, postgres storage is skipped", testDSNEnv)
+		return stores
+	}
+	require.NoError(t, logger.Initialize("error"))
+	// миграции ищутся относительно корня репозитория
+	t.Chdir("../../..")
+	s, err := NewStorage(context.Background(), dsn)
+	require.NoError(t, err)
+	stores["postgres"] = s
+	return stores
+}
+
+// testOrderID номер заказа, которого нет в бд от прошлых запусков
+func testOrderID() models.OrderID {
+	return fmt.Sprintf("%d", rand.Int64N(1e15)+1e15)
+}
+
+// creditOrder начисляет sum за новый заказ, как обработчик заказов
+func creditOrder(t *testing.T, s Storager, userID models.UserID, sum models.Money) {
+	t.Helper()
+	ctx := context.Background()
+	orderID := testOrderID()
+	require.NoError(t, s.CreateOrder(ctx, orderID, userID))
+	err := s.UpdateOrders(ctx, []*models.AccrualOrderItem{{
+		OrderID: orderID,
+		UserID:  userID,
+		Status:  models.AccrualOrderProcessed,
+		Accrual: &sum,
+	}}, "test")
+	require.NoError(t, err)
+}
+
+func TestHoldReleaseBalance(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			userID, err := s.CreateUser(ctx, "hold-"+testOrderID(), "hash")
+			require.NoError(t, err)
+			creditOrder(t, s, *userID, 500*models.MoneyScale)
+
+			balance := func() *models.Balance {
+				b, err := s.Balance(ctx, *userID, time.Hour)
+				require.NoError(t, err)
+				return b
+			}
+
+			orderID := testOrderID()
+			_, err = s.HoldPoints(ctx, *userID, orderID, 200*models.MoneyScale, time.Hour)
+			require.NoError(t, err)
+			b := balance()
+			assert.Equal(t, 300*models.MoneyScale, b.Current)
+			assert.Equal(t, 200*models.MoneyScale, b.Held)
+
+			hold, err := s.ReleaseHold(ctx, orderID)
+			require.NoError(t, err)
+			assert.Equal(t, models.HoldReleased, hold.Status)
+			b = balance()
+			assert.Equal(t, 500*models.MoneyScale, b.Current)
+			assert.Equal(t, models.Money(0), b.Held)
+			assert.Equal(t, models.Money(0), b.Withdrawn)
+
+			// после снятия резерва заказ можно зарезервировать снова
+			_, err = s.HoldPoints(ctx, *userID, orderID, 150*models.MoneyScale, time.Hour)
+			require.NoError(t, err)
+			_, err = s.HoldPoints(ctx, *userID, orderID, 150*models.MoneyScale, time.Hour)
+			assert.ErrorIs(t, err, ErrHoldExists)
+			_, err = s.CaptureHold(ctx, orderID)
+			require.NoError(t, err)
+			b = balance()
+			assert.Equal(t, 350*models.MoneyScale, b.Current)
+			assert.Equal(t, 150*models.MoneyScale, b.Withdrawn)
+
+			// возвращенные резервом баллы можно списать
+			require.NoError(t, s.Withdraw(ctx, *userID, testOrderID(), 350*models.MoneyScale))
+			b = balance()
+			assert.Equal(t, models.Money(0), b.Current)
+			assert.Equal(t, 500*models.MoneyScale, b.Withdrawn)
+		})
+	}
+}
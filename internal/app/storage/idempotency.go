@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+)
+
+var ErrIdempotencyKeyReused = errors.New("idempotency key reused with another request")
+var ErrIdempotencyInProgress = errors.New("request with this idempotency key is in progress")
+
+// BeginIdempotency занимает ключ. nil ответ - ключ новый, запрос надо выполнить.
+// Иначе возвращает сохраненный ответ или ошибку, если ключ занят другим
+// запросом или первый запрос еще выполняется. Просроченный ключ занимается заново.
+// Запрос держит ключ lockTTL: если он за это время не завершился (процесс упал),
+// ключ занимает повтор того же запроса
+func (s *storage) BeginIdempotency(ctx context.Context, userID models.UserID, key, fingerprint string, ttl, lockTTL time.Duration) (*models.IdempotentResponse, error) {
+	query := `
+	INSERT INTO idempotency_keys (user_id, key, fingerprint, expires_at, locked_until)
+	VALUES ($1, $2, $3, current_timestamp + make_interval(secs => $4), current_timestamp + make_interval(secs => $5))
+	ON CONFLICT (user_id, key) DO UPDATE SET
+	fingerprint = EXCLUDED.fingerprint,
+	status = NULL,
+	content_type = NULL,
+	response = NULL,
+	created_at = current_timestamp,
+	expires_at = EXCLUDED.expires_at,
+	locked_until = EXCLUDED.locked_until
+	WHERE idempotency_keys.expires_at <= current_timestamp
+	  OR (idempotency_keys.status IS NULL AND idempotency_keys.locked_until <= current_timestamp
+	      AND idempotency_keys.fingerprint = EXCLUDED.fingerprint)
+	RETURNING key`
+	var inserted string
+	err := s.db.QueryRowContext(ctx, query, userID, key, fingerprint, ttl.Seconds(), lockTTL.Seconds()).Scan(&inserted)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed insert idempotency key: %w", err)
+	}
+
+	query = `
+	SELECT fingerprint, status, content_type, response
+	FROM idempotency_keys
+	WHERE user_id = $1 AND key = $2`
+	var (
+		storedFingerprint string
+		status            sql.NullInt32
+		contentType       sql.NullString
+		body              []byte
+	)
+	err = s.db.QueryRowContext(ctx, query, userID, key).Scan(&storedFingerprint, &status, &contentType, &body)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// ключ только что освободили, пусть клиент повторит
+			return nil, ErrIdempotencyInProgress
+		}
+		return nil, fmt.Errorf("failed select idempotency key: %w", err)
+	}
+	if storedFingerprint != fingerprint {
+		return nil, ErrIdempotencyKeyReused
+	}
+	if !status.Valid {
+		return nil, ErrIdempotencyInProgress
+	}
+	return &models.IdempotentResponse{
+		Status:      int(status.Int32),
+		ContentType: contentType.String,
+		Body:        body,
+	}, nil
+}
+
+// FinishIdempotency сохраняет ответ для повторов
+func (s *storage) FinishIdempotency(ctx context.Context, userID models.UserID, key string, resp *models.IdempotentResponse) error {
+	query := `
+	UPDATE idempotency_keys
+	SET status = $3, content_type = $4, response = $5
+	WHERE user_id = $1 AND key = $2`
+	_, err := s.db.ExecContext(ctx, query, userID, key, resp.Status, resp.ContentType, resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed update idempotency key: %w", err)
+	}
+	return nil
+}
+
+// DeleteIdempotency освобождает ключ, если запрос не удалось выполнить
+func (s *storage) DeleteIdempotency(ctx context.Context, userID models.UserID, key string) error {
+	query := "DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2"
+	_, err := s.db.ExecContext(ctx, query, userID, key)
+	if err != nil {
+		return fmt.Errorf("failed delete idempotency key: %w", err)
+	}
+	return nil
+}
+
+func (s *storage) DeleteExpiredIdempotency(ctx context.Context) error {
+	query := "DELETE FROM idempotency_keys WHERE expires_at <= current_timestamp"
+	_, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed delete expired idempotency keys: %w", err)
+	}
+	return nil
+}
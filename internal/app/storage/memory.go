@@ -0,0 +1,1323 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+)
+
+// memOrder заказ пользователя, аналог таблицы orders
+type memOrder struct {
+	models.OrderItem
+	UserID models.UserID
+}
+
+// memOrderForProcess аналог таблицы orders_for_process
+type memOrderForProcess struct {
+	OrderID       models.OrderID
+	UserID        models.UserID
+	WhoLock       string
+	LockedAt      time.Time
+	UpdateTime    time.Time
+	Attempts      int
+	NextAttemptAt time.Time
+	CreatedAt     time.Time
+	TraceParent   string
+}
+
+// memDebetCredit аналог таблицы debet_credit
+type memDebetCredit struct {
+	OrderID    models.OrderID
+	Type       models.DebetCreditType
+	UserID     models.UserID
+	Sum        models.Money
+	CreateTime time.Time
+	// для партий (DEBET, TRANSFER_IN): несписанный остаток и когда сгорит, нулевое время - не сгорает
+	Remaining models.Money
+	ExpireAt  time.Time
+	// для EXPIRE: партия, из которой сгорели баллы
+	SourceOrderID models.OrderID
+	SourceType    models.DebetCreditType
+}
+
+// alive начисление еще не сгорело
+func (item *memDebetCredit) alive(now time.Time) bool {
+	return item.ExpireAt.IsZero() || item.ExpireAt.After(now)
+}
+
+type memDebetCreditKey struct {
+	OrderID models.OrderID
+	Type    models.DebetCreditType
+}
+
+// memStorage хранит все данные в памяти процесса.
+// Используется для тестов и локального запуска без бд.
+type memStorage struct {
+	mu sync.Mutex
+	// login -> user
+	users map[string]*User
+	// order_id -> order
+	orders map[models.OrderID]*memOrder
+	// order_id -> order for process
+	ordersForProcess map[models.OrderID]*memOrderForProcess
+	// записи в порядке вставки
+	debetCredit    []*memDebetCredit
+	debetCreditIdx map[memDebetCreditKey]*memDebetCredit
+	sessions       map[models.SessionID]*models.Session
+	deadLetters    map[models.OrderID]*models.DeadLetter
+	newOrders      broadcaster[struct{}]
+	userEvents     []*models.UserEvent
+	userEventsSeq  int64
+	userEventsSubs broadcaster[models.UserID]
+	idempotency    map[memIdempotencyKey]*memIdempotency
+	webhooks       map[models.UserID]*models.Webhook
+	// записи в порядке вставки, id = индекс + 1
+	webhookOutbox []*memWebhookOutbox
+	transfers     []*memTransfer
+	reversals     map[models.OrderID]*models.Reversal
+	holds         map[models.OrderID]*models.Hold
+	adjustments   []*models.Adjustment
+}
+
+// memTransfer аналог таблицы transfers, логины храним сразу
+type memTransfer struct {
+	ID         uuid.UUID
+	FromUserID models.UserID
+	FromLogin  string
+	ToUserID   models.UserID
+	ToLogin    string
+	Sum        models.Money
+	CreatedAt  time.Time
+}
+
+// memWebhookOutbox аналог таблицы webhook_outbox
+type memWebhookOutbox struct {
+	models.WebhookDelivery
+	NextAttemptAt time.Time
+	WhoLock       string
+	LockedAt      time.Time
+}
+
+type memIdempotencyKey struct {
+	UserID models.UserID
+	Key    string
+}
+
+// memIdempotency аналог таблицы idempotency_keys. Response nil пока запрос выполняется
+type memIdempotency struct {
+	Fingerprint string
+	Response    *models.IdempotentResponse
+	ExpiresAt   time.Time
+	LockedUntil time.Time
+}
+
+// memNow текущее время с точностью Postgres timestamp, чтобы курсоры
+// и сравнения времени вели себя так же, как в бд
+func memNow() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+func NewMemStorage() Storager {
+	return &memStorage{
+		users:            make(map[string]*User),
+		orders:           make(map[models.OrderID]*memOrder),
+		ordersForProcess: make(map[models.OrderID]*memOrderForProcess),
+		debetCreditIdx:   make(map[memDebetCreditKey]*memDebetCredit),
+		sessions:         make(map[models.SessionID]*models.Session),
+		deadLetters:      make(map[models.OrderID]*models.DeadLetter),
+		idempotency:      make(map[memIdempotencyKey]*memIdempotency),
+		webhooks:         make(map[models.UserID]*models.Webhook),
+		reversals:        make(map[models.OrderID]*models.Reversal),
+		holds:            make(map[models.OrderID]*models.Hold),
+		userEventsSubs:   broadcaster[models.UserID]{buffer: userEventsBuffer},
+	}
+}
+
+func (s *memStorage) CreateUser(ctx context.Context, login, passwordHash string) (*models.UserID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[login]; ok {
+		return nil, ErrUserExists
+	}
+	user := &User{
+		ID:    uuid.New(),
+		Login: login,
+		Hash:  passwordHash,
+	}
+	s.users[login] = user
+	userID := user.ID
+	return &userID, nil
+}
+
+func (s *memStorage) GetUser(ctx context.Context, login string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[login]
+	if !ok {
+		return nil, ErrUserOrPassword
+	}
+	u := *user
+	return &u, nil
+}
+
+func (s *memStorage) UpdateUserHash(ctx context.Context, userID models.UserID, passwordHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.ID == userID {
+			user.Hash = passwordHash
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *memStorage) CreateSession(ctx context.Context, userID models.UserID, ttl time.Duration) (*models.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := memNow()
+	session := &models.Session{
+		ID:        uuid.New(),
+		UserID:    userID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	s.sessions[session.ID] = session
+	result := *session
+	return &result, nil
+}
+
+func (s *memStorage) GetActiveSession(ctx context.Context, sessionID models.SessionID) (*models.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[sessionID]
+	if !ok || !session.Active(memNow()) {
+		return nil, ErrSessionNotFound
+	}
+	result := *session
+	return &result, nil
+}
+
+func (s *memStorage) RevokeSession(ctx context.Context, sessionID models.SessionID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[sessionID]
+	if ok && session.RevokedAt == nil {
+		now := memNow()
+		session.RevokedAt = &now
+	}
+	return nil
+}
+
+func (s *memStorage) RevokeUserSessions(ctx context.Context, userID models.UserID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := memNow()
+	for _, session := range s.sessions {
+		if session.UserID == userID && session.RevokedAt == nil {
+			session.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+func (s *memStorage) CreateOrder(ctx context.Context, orderID string, userID models.UserID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if order, ok := s.orders[orderID]; ok {
+		if order.UserID != userID {
+			return ErrOrderAnotherUser
+		}
+		return ErrOrderExists
+	}
+
+	now := memNow()
+	s.orders[orderID] = &memOrder{
+		OrderItem: models.OrderItem{
+			OrderID:    orderID,
+			Status:     models.OrderNew,
+			UploadTime: now,
+		},
+		UserID: userID,
+	}
+	s.ordersForProcess[orderID] = &memOrderForProcess{
+		OrderID:       orderID,
+		UserID:        userID,
+		UpdateTime:    now,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		TraceParent:   traceParent(ctx),
+	}
+	s.newOrders.notify(struct{}{})
+	return nil
+}
+
+func (s *memStorage) GetUserOrders(ctx context.Context, userID models.UserID, filter *models.ListFilter) (models.Orders, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	orders := make(models.Orders, 0, 10)
+	for _, order := range s.orders {
+		if order.UserID != userID || !filter.Match(order.UploadTime, order.OrderID, order.Status) {
+			continue
+		}
+		item := order.OrderItem
+		if order.Accrual != nil {
+			v := *order.Accrual
+			item.Accrual = &v
+		}
+		orders = append(orders, item)
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		return newerFirst(orders[i].UploadTime, orders[i].OrderID, orders[j].UploadTime, orders[j].OrderID)
+	})
+	if filter.Limit > 0 && uint(len(orders)) > filter.Limit {
+		orders = orders[:filter.Limit]
+	}
+	return orders, nil
+}
+
+// balance возвращает баланс пользователя без сгорающих баллов.
+// Вызывать под мьютексом.
+func (s *memStorage) balance(userID models.UserID, now time.Time) *models.Balance {
+	var balance models.Balance
+	for _, item := range s.debetCredit {
+		if item.UserID != userID {
+			continue
+		}
+		if item.Type.IsLot() {
+			if item.alive(now) {
+				balance.Current += item.Remaining
+			} else {
+				// срок прошел, но ExpirePoints еще не добрался
+				balance.Expired += item.Remaining
+			}
+		}
+		switch item.Type {
+		case models.Credit:
+			balance.Withdrawn += item.Sum
+		case models.CreditReversal:
+			// отмененное списание не считается списанным
+			balance.Withdrawn -= item.Sum
+		case models.Expire:
+			balance.Expired += item.Sum
+		}
+	}
+	for _, hold := range s.holds {
+		if hold.UserID == userID && hold.Status == models.HoldHeld {
+			balance.Held += hold.Sum
+		}
+	}
+	return &balance
+}
+
+func (s *memStorage) Balance(ctx context.Context, userID models.UserID, expiringWithin time.Duration) (*models.Balance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := memNow()
+	balance := s.balance(userID, now)
+	border := now.Add(expiringWithin)
+	for _, item := range s.debetCredit {
+		if item.UserID != userID || !item.Type.IsLot() || item.Remaining <= 0 {
+			continue
+		}
+		if item.ExpireAt.IsZero() || !item.alive(now) || item.ExpireAt.After(border) {
+			continue
+		}
+		balance.Expiring = append(balance.Expiring, models.ExpiringPoints{
+			Sum:      item.Remaining,
+			ExpireAt: item.ExpireAt,
+		})
+	}
+	sort.Slice(balance.Expiring, func(i, j int) bool {
+		return balance.Expiring[i].ExpireAt.Before(balance.Expiring[j].ExpireAt)
+	})
+	return balance, nil
+}
+
+// addDebetCredit вызывать под мьютексом
+func (s *memStorage) addDebetCredit(item *memDebetCredit) bool {
+	key := memDebetCreditKey{OrderID: item.OrderID, Type: item.Type}
+	if _, ok := s.debetCreditIdx[key]; ok {
+		return false
+	}
+	s.debetCredit = append(s.debetCredit, item)
+	s.debetCreditIdx[key] = item
+	return true
+}
+
+func (s *memStorage) Withdraw(ctx context.Context, userID models.UserID, orderID string, sum models.Money) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// заказ с резервом списывается только подтверждением резерва
+	if s.holdActive(orderID) {
+		return ErrHoldExists
+	}
+	now := memNow()
+	if s.balance(userID, now).Current < sum {
+		return ErrNotEnoughMoney
+	}
+
+	credit := &memDebetCredit{
+		OrderID:    orderID,
+		Type:       models.Credit,
+		UserID:     userID,
+		Sum:        sum,
+		CreateTime: now,
+	}
+	if !s.addDebetCredit(credit) {
+		return ErrOrderWithdrawnExists
+	}
+	// срок сгорания вернется с баллами при отмене списания
+	credit.ExpireAt = s.consumeLots(userID, sum, now)
+	s.publishEvents(userID, []models.Event{models.WithdrawEvent(orderID, sum)})
+	return nil
+}
+
+// consumeLots списывает sum с партий от старых к новым, debetCredit в порядке вставки.
+// Возвращает самый ранний срок сгорания затронутых партий. Вызывать под мьютексом
+func (s *memStorage) consumeLots(userID models.UserID, sum models.Money, now time.Time) time.Time {
+	var expireAt time.Time
+	rest := sum
+	for _, item := range s.debetCredit {
+		if rest == 0 {
+			break
+		}
+		if item.UserID != userID || !item.Type.IsLot() || item.Remaining <= 0 || !item.alive(now) {
+			continue
+		}
+		take := min(item.Remaining, rest)
+		item.Remaining -= take
+		rest -= take
+		if !item.ExpireAt.IsZero() && (expireAt.IsZero() || item.ExpireAt.Before(expireAt)) {
+			expireAt = item.ExpireAt
+		}
+	}
+	return expireAt
+}
+
+func (s *memStorage) Withdrawals(ctx context.Context, userID models.UserID, filter *models.ListFilter) (models.Withdrawals, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	withdrawals := make(models.Withdrawals, 0, 10)
+	for _, item := range s.debetCredit {
+		if item.UserID != userID || item.Type != models.Credit {
+			continue
+		}
+		if !filter.Match(item.CreateTime, item.OrderID, "") {
+			continue
+		}
+		withdrawal := models.Withdrawal{
+			OrderID:    item.OrderID,
+			Sum:        item.Sum,
+			CreateTime: item.CreateTime,
+		}
+		if reversal, ok := s.reversals[item.OrderID]; ok {
+			withdrawal.Reversed = true
+			withdrawal.ReversedAt = &reversal.CreatedAt
+		}
+		withdrawals = append(withdrawals, withdrawal)
+	}
+	sort.Slice(withdrawals, func(i, j int) bool {
+		return newerFirst(withdrawals[i].CreateTime, withdrawals[i].OrderID, withdrawals[j].CreateTime, withdrawals[j].OrderID)
+	})
+	if filter.Limit > 0 && uint(len(withdrawals)) > filter.Limit {
+		withdrawals = withdrawals[:filter.Limit]
+	}
+	return withdrawals, nil
+}
+
+// newerFirst порядок списков: по времени и id от новых к старым
+func newerFirst(t1 time.Time, id1 string, t2 time.Time, id2 string) bool {
+	if !t1.Equal(t2) {
+		return t1.After(t2)
+	}
+	return id1 > id2
+}
+
+func (s *memStorage) CleanupAfterCrash(ctx context.Context, t time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	border := memNow().Add(-t)
+	for _, item := range s.ordersForProcess {
+		if item.WhoLock != "" && !item.LockedAt.After(border) {
+			item.WhoLock = ""
+			item.LockedAt = time.Time{}
+		}
+	}
+	for _, item := range s.webhookOutbox {
+		if item.WhoLock != "" && !item.LockedAt.After(border) {
+			item.WhoLock = ""
+			item.LockedAt = time.Time{}
+		}
+	}
+	return nil
+}
+
+func (s *memStorage) GetOrdersForProcess(ctx context.Context, who string, limit uint) (models.ProcessingOrders, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := memNow()
+	free := make([]*memOrderForProcess, 0, len(s.ordersForProcess))
+	for _, item := range s.ordersForProcess {
+		if item.WhoLock == "" && !item.NextAttemptAt.After(now) {
+			free = append(free, item)
+		}
+	}
+	sort.Slice(free, func(i, j int) bool {
+		return free[i].NextAttemptAt.Before(free[j].NextAttemptAt)
+	})
+	if uint(len(free)) > limit {
+		free = free[:limit]
+	}
+
+	result := make(models.ProcessingOrders, 0, len(free))
+	for _, item := range free {
+		item.WhoLock = who
+		item.LockedAt = now
+		result = append(result, models.ProcessingOrderItem{
+			OrderID:     item.OrderID,
+			UserID:      item.UserID,
+			Attempts:    item.Attempts,
+			Age:         now.Sub(item.CreatedAt),
+			TraceParent: item.TraceParent,
+		})
+	}
+	return result, nil
+}
+
+func (s *memStorage) UpdateOrders(ctx context.Context, data []*models.AccrualOrderItem, who string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// как в бд: повторное начисление за заказ - ошибка, и вся пачка не сохраняется
+	debets := make(map[models.OrderID]struct{}, len(data))
+	for _, ptr := range data {
+		if ptr.Dead || !ptr.Terminated() || ptr.Accrual == nil {
+			continue
+		}
+		_, exists := s.debetCreditIdx[memDebetCreditKey{OrderID: ptr.OrderID, Type: models.Debet}]
+		if _, ok := debets[ptr.OrderID]; ok || exists {
+			return fmt.Errorf("failed exec debet: debet for order %s exists", ptr.OrderID)
+		}
+		debets[ptr.OrderID] = struct{}{}
+	}
+
+	now := memNow()
+	for _, ptr := range data {
+		if ptr.Dead {
+			item, ok := s.ordersForProcess[ptr.OrderID]
+			// заказ уже не наш: его обработали или передали другому обработчику
+			if !ok || item.WhoLock != who {
+				continue
+			}
+			s.deadLetters[ptr.OrderID] = &models.DeadLetter{
+				OrderID:   ptr.OrderID,
+				UserID:    item.UserID,
+				Attempts:  ptr.Attempts,
+				LastError: ptr.FailReason(),
+				CreatedAt: item.CreatedAt,
+				DeadAt:    now,
+			}
+			delete(s.ordersForProcess, ptr.OrderID)
+			if order, ok := s.orders[ptr.OrderID]; ok {
+				prev := order.Status
+				order.Status = models.OrderStale
+				s.publishEvents(order.UserID, models.OrderEvents(ptr.OrderID, prev, order.Status, nil, false))
+			}
+			continue
+		}
+		if !ptr.Terminated() {
+			item, ok := s.ordersForProcess[ptr.OrderID]
+			if ok && item.WhoLock == who {
+				item.WhoLock = ""
+				item.LockedAt = time.Time{}
+				item.UpdateTime = now
+				item.Attempts = ptr.Attempts
+				item.NextAttemptAt = now.Add(ptr.NextAttemptIn)
+			}
+		}
+		if ptr.Error != nil {
+			continue
+		}
+
+		order, ok := s.orders[ptr.OrderID]
+		if !ok {
+			order = &memOrder{
+				OrderItem: models.OrderItem{
+					OrderID:    ptr.OrderID,
+					UploadTime: now,
+				},
+				UserID: ptr.UserID,
+			}
+			s.orders[ptr.OrderID] = order
+		}
+		prev := order.Status
+		order.Status = ptr.Status.OrderStatus()
+		order.Accrual = nil
+		if ptr.Accrual != nil {
+			v := *ptr.Accrual
+			order.Accrual = &v
+		}
+		// у INVALID начисления нет
+		credited := ptr.Terminated() && ptr.Accrual != nil
+		s.publishEvents(ptr.UserID, models.OrderEvents(ptr.OrderID, prev, order.Status, order.Accrual, credited))
+
+		if ptr.Terminated() {
+			// у INVALID начисления нет
+			if ptr.Accrual != nil {
+				item := &memDebetCredit{
+					OrderID:    ptr.OrderID,
+					Type:       models.Debet,
+					UserID:     ptr.UserID,
+					Sum:        *ptr.Accrual,
+					CreateTime: now,
+					Remaining:  *ptr.Accrual,
+				}
+				if ptr.PointsTTL > 0 {
+					item.ExpireAt = now.Add(ptr.PointsTTL)
+				}
+				s.addDebetCredit(item)
+			}
+			delete(s.ordersForProcess, ptr.OrderID)
+		}
+	}
+	return nil
+}
+
+func (s *memStorage) CleanOrdersForProcess(ctx context.Context, who string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, item := range s.ordersForProcess {
+		if item.WhoLock == who {
+			item.WhoLock = ""
+			item.LockedAt = time.Time{}
+		}
+	}
+	return nil
+}
+
+func (s *memStorage) ListDeadLetters(ctx context.Context, limit uint) (models.DeadLetters, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make(models.DeadLetters, 0, len(s.deadLetters))
+	for _, item := range s.deadLetters {
+		result = append(result, *item)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].DeadAt.After(result[j].DeadAt)
+	})
+	if uint(len(result)) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+func (s *memStorage) RequeueDeadLetter(ctx context.Context, orderID models.OrderID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.deadLetters[orderID]
+	if !ok {
+		return ErrDeadLetterNotFound
+	}
+	delete(s.deadLetters, orderID)
+
+	now := memNow()
+	s.ordersForProcess[orderID] = &memOrderForProcess{
+		OrderID:       orderID,
+		UserID:        item.UserID,
+		UpdateTime:    now,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		TraceParent:   traceParent(ctx),
+	}
+	if order, ok := s.orders[orderID]; ok {
+		order.Status = models.OrderNew
+	}
+	s.newOrders.notify(struct{}{})
+	return nil
+}
+
+func (s *memStorage) ListenNewOrders(ctx context.Context) (<-chan struct{}, error) {
+	return s.newOrders.subscribe(ctx), nil
+}
+
+func (s *memStorage) QueueStats(ctx context.Context) (*models.QueueStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := memNow()
+	stats := models.QueueStats{
+		Total:  len(s.ordersForProcess),
+		Locked: make(map[string]int),
+	}
+	for _, item := range s.ordersForProcess {
+		if item.WhoLock != "" {
+			stats.Locked[item.WhoLock]++
+			continue
+		}
+		if !item.NextAttemptAt.After(now) {
+			stats.Ready++
+		}
+	}
+	return &stats, nil
+}
+
+func (s *memStorage) Ping(ctx context.Context) error {
+	return nil
+}
+
+// MigrationVersion миграций нет, схема всегда актуальна
+func (s *memStorage) MigrationVersion(ctx context.Context) (uint, bool, error) {
+	return SchemaVersion, false, nil
+}
+
+func (s *memStorage) BeginIdempotency(ctx context.Context, userID models.UserID, key, fingerprint string, ttl, lockTTL time.Duration) (*models.IdempotentResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := memNow()
+	k := memIdempotencyKey{UserID: userID, Key: key}
+	item, ok := s.idempotency[k]
+	stale := ok && item.Response == nil && !item.LockedUntil.After(now) && item.Fingerprint == fingerprint
+	if !ok || !item.ExpiresAt.After(now) || stale {
+		s.idempotency[k] = &memIdempotency{
+			Fingerprint: fingerprint,
+			ExpiresAt:   now.Add(ttl),
+			LockedUntil: now.Add(lockTTL),
+		}
+		return nil, nil
+	}
+	if item.Fingerprint != fingerprint {
+		return nil, ErrIdempotencyKeyReused
+	}
+	if item.Response == nil {
+		return nil, ErrIdempotencyInProgress
+	}
+	resp := *item.Response
+	return &resp, nil
+}
+
+func (s *memStorage) FinishIdempotency(ctx context.Context, userID models.UserID, key string, resp *models.IdempotentResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if item, ok := s.idempotency[memIdempotencyKey{UserID: userID, Key: key}]; ok {
+		saved := *resp
+		item.Response = &saved
+	}
+	return nil
+}
+
+func (s *memStorage) DeleteIdempotency(ctx context.Context, userID models.UserID, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.idempotency, memIdempotencyKey{UserID: userID, Key: key})
+	return nil
+}
+
+func (s *memStorage) DeleteExpiredIdempotency(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := memNow()
+	for k, item := range s.idempotency {
+		if !item.ExpiresAt.After(now) {
+			delete(s.idempotency, k)
+		}
+	}
+	return nil
+}
+
+func (s *memStorage) SetWebhook(ctx context.Context, hook *models.Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hook.CreatedAt = memNow()
+	stored := *hook
+	s.webhooks[hook.UserID] = &stored
+	return nil
+}
+
+func (s *memStorage) GetWebhook(ctx context.Context, userID models.UserID) (*models.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hook, ok := s.webhooks[userID]
+	if !ok {
+		return nil, ErrWebhookNotFound
+	}
+	result := *hook
+	result.Secret = ""
+	return &result, nil
+}
+
+func (s *memStorage) DeleteWebhook(ctx context.Context, userID models.UserID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.webhooks[userID]; !ok {
+		return ErrWebhookNotFound
+	}
+	delete(s.webhooks, userID)
+	for _, item := range s.webhookOutbox {
+		if item.UserID == userID && item.Status == models.WebhookPending {
+			item.Status = models.WebhookFailed
+			item.LastError = "webhook deleted"
+			item.WhoLock = ""
+			item.LockedAt = time.Time{}
+		}
+	}
+	return nil
+}
+
+// publishEvents пишет события в журнал пользователя и outbox вебхука.
+// Вызывать под мьютексом
+func (s *memStorage) publishEvents(userID models.UserID, events []models.Event) {
+	if len(events) == 0 {
+		return
+	}
+	_, withWebhook := s.webhooks[userID]
+	now := memNow()
+	for _, event := range events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			// в событии только простые типы
+			continue
+		}
+		s.userEventsSeq++
+		s.userEvents = append(s.userEvents, &models.UserEvent{
+			ID:        s.userEventsSeq,
+			UserID:    userID,
+			Type:      event.Type,
+			Data:      payload,
+			CreatedAt: now,
+		})
+		if !withWebhook {
+			continue
+		}
+		s.webhookOutbox = append(s.webhookOutbox, &memWebhookOutbox{
+			WebhookDelivery: models.WebhookDelivery{
+				ID:        int64(len(s.webhookOutbox) + 1),
+				UserID:    userID,
+				Event:     event.Type,
+				Payload:   payload,
+				Status:    models.WebhookPending,
+				CreatedAt: now,
+			},
+			NextAttemptAt: now,
+		})
+	}
+	s.userEventsSubs.notify(userID)
+}
+
+func (s *memStorage) WebhookDeliveries(ctx context.Context, userID models.UserID, filter *models.ListFilter) (models.WebhookDeliveries, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make(models.WebhookDeliveries, 0, 10)
+	for _, item := range s.webhookOutbox {
+		if item.UserID != userID {
+			continue
+		}
+		cursor := item.Cursor()
+		if !filter.Match(cursor.Time, cursor.ID, "") {
+			continue
+		}
+		delivery := item.WebhookDelivery
+		if item.DeliveredAt != nil {
+			t := *item.DeliveredAt
+			delivery.DeliveredAt = &t
+		}
+		result = append(result, delivery)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		ci, cj := result[i].Cursor(), result[j].Cursor()
+		return newerFirst(ci.Time, ci.ID, cj.Time, cj.ID)
+	})
+	if filter.Limit > 0 && uint(len(result)) > filter.Limit {
+		result = result[:filter.Limit]
+	}
+	return result, nil
+}
+
+func (s *memStorage) ClaimWebhookDeliveries(ctx context.Context, who string, limit uint) (models.WebhookDeliveries, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := memNow()
+	ready := make([]*memWebhookOutbox, 0, limit)
+	for _, item := range s.webhookOutbox {
+		if item.Status != models.WebhookPending || item.WhoLock != "" || item.NextAttemptAt.After(now) {
+			continue
+		}
+		if _, ok := s.webhooks[item.UserID]; !ok {
+			continue
+		}
+		ready = append(ready, item)
+	}
+	sort.Slice(ready, func(i, j int) bool {
+		return ready[i].NextAttemptAt.Before(ready[j].NextAttemptAt)
+	})
+	if uint(len(ready)) > limit {
+		ready = ready[:limit]
+	}
+
+	result := make(models.WebhookDeliveries, 0, len(ready))
+	for _, item := range ready {
+		item.WhoLock = who
+		item.LockedAt = now
+		delivery := item.WebhookDelivery
+		hook := s.webhooks[item.UserID]
+		delivery.URL = hook.URL
+		delivery.Secret = hook.Secret
+		result = append(result, delivery)
+	}
+	return result, nil
+}
+
+func (s *memStorage) FinishWebhookDeliveries(ctx context.Context, results []*models.WebhookResult, who string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := memNow()
+	for _, res := range results {
+		if res.ID <= 0 || res.ID > int64(len(s.webhookOutbox)) {
+			continue
+		}
+		item := s.webhookOutbox[res.ID-1]
+		if item.WhoLock != who {
+			continue
+		}
+		item.WhoLock = ""
+		item.LockedAt = time.Time{}
+		item.Attempts = res.Attempts
+		item.LastStatusCode = res.StatusCode
+		item.LastError = res.Error
+		item.Status = webhookStatus(res)
+		item.NextAttemptAt = now.Add(res.NextAttemptIn)
+		if res.Delivered {
+			item.DeliveredAt = &now
+		}
+	}
+	return nil
+}
+
+func (s *memStorage) UserEvents(ctx context.Context, userID models.UserID, afterID int64, limit uint) (models.UserEvents, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make(models.UserEvents, 0, 10)
+	for _, item := range s.userEvents {
+		if item.ID <= afterID || item.UserID != userID {
+			continue
+		}
+		result = append(result, *item)
+		if uint(len(result)) >= limit {
+			break
+		}
+	}
+	return result, nil
+}
+
+func (s *memStorage) LastUserEventID(ctx context.Context, userID models.UserID) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := len(s.userEvents) - 1; i >= 0; i-- {
+		if s.userEvents[i].UserID == userID {
+			return s.userEvents[i].ID, nil
+		}
+	}
+	return 0, nil
+}
+
+func (s *memStorage) ListenUserEvents(ctx context.Context) (<-chan models.UserID, error) {
+	return s.userEventsSubs.subscribe(ctx), nil
+}
+
+func (s *memStorage) DeleteExpiredUserEvents(ctx context.Context, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	border := memNow().Add(-ttl)
+	s.userEvents = slices.DeleteFunc(s.userEvents, func(item *models.UserEvent) bool {
+		return !item.CreatedAt.After(border)
+	})
+	return nil
+}
+
+func (s *memStorage) ExpirePoints(ctx context.Context, limit uint) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := memNow()
+	expired := make(map[models.UserID][]models.Event)
+	count := 0
+	for _, item := range s.debetCredit {
+		if uint(count) >= limit {
+			break
+		}
+		if !item.Type.IsLot() || item.Remaining <= 0 || item.alive(now) {
+			continue
+		}
+		// EXPIRE у каждой партии свой: у партий разных пользователей может быть один order_id
+		s.addDebetCredit(&memDebetCredit{
+			OrderID:       uuid.NewString(),
+			Type:          models.Expire,
+			UserID:        item.UserID,
+			Sum:           item.Remaining,
+			CreateTime:    now,
+			SourceOrderID: item.OrderID,
+			SourceType:    item.Type,
+		})
+		expired[item.UserID] = append(expired[item.UserID], models.ExpireEvent(item.OrderID, item.Remaining))
+		item.Remaining = 0
+		count++
+	}
+	for userID, events := range expired {
+		s.publishEvents(userID, events)
+	}
+	return count, nil
+}
+
+func (s *memStorage) Transfer(ctx context.Context, fromUserID models.UserID, toLogin string, sum, dailyLimit models.Money) (*models.Transfer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	to, ok := s.users[toLogin]
+	if !ok {
+		return nil, ErrRecipientNotFound
+	}
+	if to.ID == fromUserID {
+		return nil, ErrSelfTransfer
+	}
+	var fromLogin string
+	for login, user := range s.users {
+		if user.ID == fromUserID {
+			fromLogin = login
+			break
+		}
+	}
+
+	now := memNow()
+	if dailyLimit > 0 {
+		var sent models.Money
+		dayAgo := now.Add(-24 * time.Hour)
+		for _, item := range s.debetCredit {
+			if item.UserID == fromUserID && item.Type == models.TransferOut && item.CreateTime.After(dayAgo) {
+				sent += item.Sum
+			}
+		}
+		if sent+sum > dailyLimit {
+			return nil, ErrTransferLimitExceeded
+		}
+	}
+	if s.balance(fromUserID, now).Current < sum {
+		return nil, ErrNotEnoughMoney
+	}
+	expireAt := s.consumeLots(fromUserID, sum, now)
+
+	t := &memTransfer{
+		ID:         uuid.New(),
+		FromUserID: fromUserID,
+		FromLogin:  fromLogin,
+		ToUserID:   to.ID,
+		ToLogin:    toLogin,
+		Sum:        sum,
+		CreatedAt:  now,
+	}
+	s.transfers = append(s.transfers, t)
+	s.addDebetCredit(&memDebetCredit{
+		OrderID:    t.ID.String(),
+		Type:       models.TransferOut,
+		UserID:     fromUserID,
+		Sum:        sum,
+		CreateTime: now,
+	})
+	s.addDebetCredit(&memDebetCredit{
+		OrderID:    t.ID.String(),
+		Type:       models.TransferIn,
+		UserID:     to.ID,
+		Sum:        sum,
+		CreateTime: now,
+		Remaining:  sum,
+		ExpireAt:   expireAt,
+	})
+
+	sent := t.view(fromUserID)
+	received := t.view(to.ID)
+	s.publishEvents(fromUserID, []models.Event{models.TransferEvent(&sent)})
+	s.publishEvents(to.ID, []models.Event{models.TransferEvent(&received)})
+	return &sent, nil
+}
+
+// view перевод с точки зрения участника userID
+func (t *memTransfer) view(userID models.UserID) models.Transfer {
+	item := models.Transfer{
+		ID:           t.ID,
+		Direction:    models.TransferSent,
+		Counterparty: t.ToLogin,
+		Sum:          t.Sum,
+		CreatedAt:    t.CreatedAt,
+	}
+	if t.FromUserID != userID {
+		item.Direction = models.TransferReceived
+		item.Counterparty = t.FromLogin
+	}
+	return item
+}
+
+func (s *memStorage) Transfers(ctx context.Context, userID models.UserID, filter *models.ListFilter) (models.Transfers, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	transfers := make(models.Transfers, 0, 10)
+	for _, t := range s.transfers {
+		if t.FromUserID != userID && t.ToUserID != userID {
+			continue
+		}
+		if !filter.Match(t.CreatedAt, t.ID.String(), "") {
+			continue
+		}
+		transfers = append(transfers, t.view(userID))
+	}
+	sort.Slice(transfers, func(i, j int) bool {
+		return newerFirst(transfers[i].CreatedAt, transfers[i].ID.String(), transfers[j].CreatedAt, transfers[j].ID.String())
+	})
+	if filter.Limit > 0 && uint(len(transfers)) > filter.Limit {
+		transfers = transfers[:filter.Limit]
+	}
+	return transfers, nil
+}
+
+func (s *memStorage) ReverseWithdrawal(ctx context.Context, reversal *models.Reversal) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	credit, ok := s.debetCreditIdx[memDebetCreditKey{OrderID: reversal.OrderID, Type: models.Credit}]
+	if !ok {
+		return ErrWithdrawalNotFound
+	}
+	if _, ok := s.reversals[reversal.OrderID]; ok {
+		return ErrWithdrawalReversed
+	}
+	now := memNow()
+	reversal.UserID = credit.UserID
+	reversal.Sum = credit.Sum
+	reversal.CreatedAt = now
+	saved := *reversal
+	s.reversals[reversal.OrderID] = &saved
+	s.addDebetCredit(&memDebetCredit{
+		OrderID:    reversal.OrderID,
+		Type:       models.CreditReversal,
+		UserID:     credit.UserID,
+		Sum:        credit.Sum,
+		CreateTime: now,
+		Remaining:  credit.Sum,
+		ExpireAt:   credit.ExpireAt,
+	})
+	s.publishEvents(credit.UserID, []models.Event{models.ReversalEvent(reversal.OrderID, reversal.Sum)})
+	return nil
+}
+
+func (s *memStorage) HoldPoints(ctx context.Context, userID models.UserID, orderID models.OrderID, sum models.Money, ttl time.Duration) (*models.Hold, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.debetCreditIdx[memDebetCreditKey{OrderID: orderID, Type: models.Credit}]; ok {
+		return nil, ErrOrderWithdrawnExists
+	}
+	if s.holdActive(orderID) {
+		return nil, ErrHoldExists
+	}
+	now := memNow()
+	if s.balance(userID, now).Current < sum {
+		return nil, ErrNotEnoughMoney
+	}
+
+	// снятый резерв заменяем новым
+	hold := &models.Hold{
+		ID:        uuid.New(),
+		OrderID:   orderID,
+		UserID:    userID,
+		Sum:       sum,
+		Status:    models.HoldHeld,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+	s.holds[orderID] = hold
+	s.addDebetCredit(&memDebetCredit{
+		OrderID:    hold.ID.String(),
+		Type:       models.CreditHold,
+		UserID:     userID,
+		Sum:        sum,
+		CreateTime: now,
+		ExpireAt:   s.consumeLots(userID, sum, now),
+	})
+	s.publishEvents(userID, []models.Event{models.HoldEvent(orderID, sum)})
+	result := *hold
+	return &result, nil
+}
+
+func (s *memStorage) CaptureHold(ctx context.Context, orderID models.OrderID) (*models.Hold, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.finishHold(orderID, models.HoldCaptured, memNow())
+}
+
+func (s *memStorage) ReleaseHold(ctx context.Context, orderID models.OrderID) (*models.Hold, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.finishHold(orderID, models.HoldReleased, memNow())
+}
+
+// holdActive у заказа есть действующий или подтвержденный резерв. Вызывать под мьютексом
+func (s *memStorage) holdActive(orderID models.OrderID) bool {
+	hold, ok := s.holds[orderID]
+	return ok && hold.Status != models.HoldReleased
+}
+
+// finishHold вызывать под мьютексом
+func (s *memStorage) finishHold(orderID models.OrderID, status models.HoldStatus, now time.Time) (*models.Hold, error) {
+	hold, ok := s.holds[orderID]
+	if !ok {
+		return nil, ErrHoldNotFound
+	}
+	if hold.Status != models.HoldHeld {
+		return nil, ErrHoldFinished
+	}
+	if status == models.HoldCaptured && !hold.ExpiresAt.After(now) {
+		return nil, ErrHoldExpired
+	}
+	held := s.debetCreditIdx[memDebetCreditKey{OrderID: hold.ID.String(), Type: models.CreditHold}]
+	item := &memDebetCredit{
+		OrderID:    orderID,
+		UserID:     hold.UserID,
+		Sum:        hold.Sum,
+		CreateTime: now,
+		ExpireAt:   held.ExpireAt,
+	}
+	var event models.Event
+	switch status {
+	case models.HoldCaptured:
+		item.Type = models.Credit
+		event = models.WithdrawEvent(orderID, hold.Sum)
+	case models.HoldReleased:
+		// возврат под id резерва, как и HOLD
+		item.OrderID = hold.ID.String()
+		item.Type = models.CreditRelease
+		item.Remaining = hold.Sum
+		event = models.ReleaseEvent(orderID, hold.Sum)
+	}
+	if !s.addDebetCredit(item) {
+		if status == models.HoldCaptured {
+			return nil, ErrOrderWithdrawnExists
+		}
+		return nil, fmt.Errorf("failed insert debet_credit: release for hold %s exists", hold.ID)
+	}
+	hold.Status = status
+	finishedAt := now
+	hold.FinishedAt = &finishedAt
+	s.publishEvents(hold.UserID, []models.Event{event})
+	result := *hold
+	return &result, nil
+}
+
+func (s *memStorage) ReleaseStaleHolds(ctx context.Context, limit uint) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := memNow()
+	count := 0
+	for orderID, hold := range s.holds {
+		if uint(count) >= limit {
+			break
+		}
+		if hold.Status != models.HoldHeld || hold.ExpiresAt.After(now) {
+			continue
+		}
+		if _, err := s.finishHold(orderID, models.HoldReleased, now); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (s *memStorage) AdjustBalance(ctx context.Context, adj *models.Adjustment, pointsTTL time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := memNow()
+	item := &memDebetCredit{
+		Type:       models.CreditAdjustment,
+		UserID:     adj.UserID,
+		Sum:        adj.Sum,
+		CreateTime: now,
+	}
+	if adj.Sum < 0 {
+		if s.balance(adj.UserID, now).Current < -adj.Sum {
+			return ErrNotEnoughMoney
+		}
+		s.consumeLots(adj.UserID, -adj.Sum, now)
+	} else {
+		item.Remaining = adj.Sum
+		if pointsTTL > 0 {
+			item.ExpireAt = now.Add(pointsTTL)
+		}
+	}
+
+	adj.ID = uuid.New()
+	adj.CreatedAt = now
+	saved := *adj
+	s.adjustments = append(s.adjustments, &saved)
+	item.OrderID = adj.ID.String()
+	s.addDebetCredit(item)
+	s.publishEvents(adj.UserID, []models.Event{models.AdjustmentEvent(adj)})
+	return nil
+}
+
+func (s *memStorage) Adjustments(ctx context.Context, userID models.UserID, filter *models.ListFilter) (models.Adjustments, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make(models.Adjustments, 0, 10)
+	for _, adj := range s.adjustments {
+		if adj.UserID != userID || !filter.Match(adj.CreatedAt, adj.ID.String(), "") {
+			continue
+		}
+		result = append(result, *adj)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return newerFirst(result[i].CreatedAt, result[i].ID.String(), result[j].CreatedAt, result[j].ID.String())
+	})
+	if filter.Limit > 0 && uint(len(result)) > filter.Limit {
+		result = result[:filter.Limit]
+	}
+	return result, nil
+}
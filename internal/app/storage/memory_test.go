@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemUpdateOrdersDuplicateDebet(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStorage()
+	userID, err := s.CreateUser(ctx, "user", "hash")
+	require.NoError(t, err)
+	for _, orderID := range []string{"12345678903", "2377225624"} {
+		require.NoError(t, s.CreateOrder(ctx, orderID, *userID))
+	}
+	sum := 100 * models.MoneyScale
+	processed := func(orderID models.OrderID) *models.AccrualOrderItem {
+		return &models.AccrualOrderItem{OrderID: orderID, UserID: *userID, Status: models.AccrualOrderProcessed, Accrual: &sum}
+	}
+
+	require.NoError(t, s.UpdateOrders(ctx, []*models.AccrualOrderItem{processed("12345678903")}, "test"))
+	// повторное начисление отклоняет всю пачку, как уникальный ключ в бд
+	err = s.UpdateOrders(ctx, []*models.AccrualOrderItem{processed("2377225624"), processed("12345678903")}, "test")
+	require.Error(t, err)
+	err = s.UpdateOrders(ctx, []*models.AccrualOrderItem{processed("2377225624"), processed("2377225624")}, "test")
+	require.Error(t, err)
+
+	balance, err := s.Balance(ctx, *userID, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 100*models.MoneyScale, balance.Current)
+	orders, err := s.GetUserOrders(ctx, *userID, &models.ListFilter{})
+	require.NoError(t, err)
+	for _, order := range orders {
+		if order.OrderID == "2377225624" {
+			assert.Equal(t, models.OrderNew, order.Status)
+		}
+	}
+}
+
+func TestMemCaptureHoldWithdrawnOrder(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStorage()
+	userID, err := s.CreateUser(ctx, "user", "hash")
+	require.NoError(t, err)
+	creditLot(t, s, *userID, "12345678903", 100*models.MoneyScale, 0)
+	_, err = s.HoldPoints(ctx, *userID, "2377225624", 30*models.MoneyScale, time.Hour)
+	require.NoError(t, err)
+
+	// списание за заказ уже есть, резерв подтвердить нельзя
+	ms := s.(*memStorage)
+	ms.addDebetCredit(&memDebetCredit{OrderID: "2377225624", Type: models.Credit, UserID: *userID, Sum: 10 * models.MoneyScale})
+	_, err = s.CaptureHold(ctx, "2377225624")
+	require.ErrorIs(t, err, ErrOrderWithdrawnExists)
+	assert.Equal(t, models.HoldHeld, ms.holds["2377225624"].Status)
+
+	// резерв остался действующим, его можно снять
+	hold, err := s.ReleaseHold(ctx, "2377225624")
+	require.NoError(t, err)
+	assert.Equal(t, models.HoldReleased, hold.Status)
+}
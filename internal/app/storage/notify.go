@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+	"github.com/serg2014/go-musthave-diploma/internal/logger"
+	"go.uber.org/zap"
+)
+
+// NewOrdersChannel канал postgres NOTIFY о новых заказах в orders_for_process
+const NewOrdersChannel = "new_orders"
+
+// UserEventsChannel канал postgres NOTIFY о новых записях user_events, payload - user_id
+const UserEventsChannel = "user_events"
+
+// пауза перед повторным подключением слушателя
+const listenReconnectDelay = 5 * time.Second
+
+// буфер канала событий пользователей: получатель быстрый, но уведомления идут пачками
+const userEventsBuffer = 64
+
+// signal неблокирующая отправка: сигналы схлопываются,
+// получателю достаточно знать что что-то изменилось
+func signal[T any](ch chan T, v T) {
+	select {
+	case ch <- v:
+	default:
+	}
+}
+
+// broadcaster уведомления внутри процесса, используется в memStorage.
+// Отправка не блокирует: при переполненном буфере уведомление теряется
+type broadcaster[T any] struct {
+	mu     sync.Mutex
+	subs   map[chan T]struct{}
+	buffer int
+}
+
+func (b *broadcaster[T]) subscribe(ctx context.Context) <-chan T {
+	ch := make(chan T, max(b.buffer, 1))
+	b.mu.Lock()
+	if b.subs == nil {
+		b.subs = make(map[chan T]struct{})
+	}
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		delete(b.subs, ch)
+		b.mu.Unlock()
+		close(ch)
+	}()
+	return ch
+}
+
+func (b *broadcaster[T]) notify(v T) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		signal(ch, v)
+	}
+}
+
+// ListenNewOrders слушает NOTIFY на отдельном соединении, не из пула database/sql.
+// При обрыве переподключается и на всякий случай сигналит: уведомления могли потеряться.
+// Канал закрывается после отмены ctx
+func (s *storage) ListenNewOrders(ctx context.Context) (<-chan struct{}, error) {
+	ch := make(chan struct{}, 1)
+	err := s.listenLoop(ctx, NewOrdersChannel,
+		func(string) { signal(ch, struct{}{}) },
+		func() { signal(ch, struct{}{}) },
+		func() { close(ch) },
+	)
+	if err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+// ListenUserEvents отдает id пользователей, у которых появились события.
+// После переподключения отдает uuid.Nil: события могли появиться у любого
+func (s *storage) ListenUserEvents(ctx context.Context) (<-chan models.UserID, error) {
+	ch := make(chan models.UserID, userEventsBuffer)
+	send := func(userID models.UserID) {
+		select {
+		case ch <- userID:
+		case <-ctx.Done():
+		}
+	}
+	err := s.listenLoop(ctx, UserEventsChannel,
+		func(payload string) {
+			userID, err := uuid.Parse(payload)
+			if err != nil {
+				logger.Log.Error("bad user_events notification", zap.String("payload", payload))
+				return
+			}
+			send(userID)
+		},
+		func() { send(uuid.Nil) },
+		func() { close(ch) },
+	)
+	if err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+// listenLoop подключается и в отдельной горутине вызывает onNotify на каждое уведомление,
+// onReconnect после переподключения и onStop после отмены ctx
+func (s *storage) listenLoop(ctx context.Context, channel string, onNotify func(payload string), onReconnect, onStop func()) error {
+	conn, err := s.listen(ctx, channel)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		defer onStop()
+		for {
+			if conn != nil {
+				n, err := conn.WaitForNotification(ctx)
+				if err == nil {
+					onNotify(n.Payload)
+					continue
+				}
+				conn.Close(context.Background())
+				conn = nil
+				if ctx.Err() != nil {
+					return
+				}
+				logger.Log.Error("failed wait notification", zap.String("channel", channel), zap.Error(err))
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(listenReconnectDelay):
+			}
+			conn, err = s.listen(ctx, channel)
+			if err != nil {
+				logger.Log.Error("failed reconnect listener", zap.String("channel", channel), zap.Error(err))
+				continue
+			}
+			logger.Log.Info("listener reconnected", zap.String("channel", channel))
+			onReconnect()
+		}
+	}()
+	return nil
+}
+
+func (s *storage) listen(ctx context.Context, channel string) (*pgx.Conn, error) {
+	conn, err := pgx.Connect(ctx, s.dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed connect listener: %w", err)
+	}
+	_, err = conn.Exec(ctx, "LISTEN "+channel)
+	if err != nil {
+		conn.Close(context.Background())
+		return nil, fmt.Errorf("failed listen %s: %w", channel, err)
+	}
+	return conn, nil
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+)
+
+// lotAlive условие для строк debet_credit: начисление еще не сгорело
+const lotAlive = "(expire_at IS NULL OR expire_at > current_timestamp)"
+
+// lotType строки debet_credit, которые являются партиями баллов:
+// начисления за заказы, полученные переводы, возвраты отмененных списаний и снятых резервов,
+// положительные корректировки
+const lotType = `"type" IN ('DEBET', 'TRANSFER_IN', 'REVERSAL', 'RELEASE', 'ADJUSTMENT')`
+
+// lockBalance блокирует записи пользователя до конца транзакции,
+// чтобы параллельные списания не ушли в минус
+func lockBalance(ctx context.Context, tx *sql.Tx, userID models.UserID) error {
+	query := `
+		SELECT "sum", "type"
+		FROM debet_credit
+		WHERE user_id = $1
+		FOR UPDATE
+	`
+	_, err := tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return fmt.Errorf("failed for update: %w", err)
+	}
+	return nil
+}
+
+// availablePoints несписанный остаток действующих партий пользователя
+func availablePoints(ctx context.Context, tx *sql.Tx, userID models.UserID) (models.Money, error) {
+	query := `
+		SELECT coalesce(sum(remaining), 0)
+		FROM debet_credit
+		WHERE user_id = $1 AND ` + lotType + ` AND remaining > 0 AND ` + lotAlive
+	var available models.Money
+	err := tx.QueryRowContext(ctx, query, userID).Scan(&available)
+	if err != nil {
+		return 0, fmt.Errorf("failed balance from debet_credit: %w", err)
+	}
+	return available, nil
+}
+
+// consumeLots списывает sum с партий от старых к новым: из каждой берем остаток,
+// пока не наберется сумма. Возвращает самый ранний срок сгорания затронутых партий
+func consumeLots(ctx context.Context, tx *sql.Tx, userID models.UserID, sum models.Money) (sql.NullTime, error) {
+	query := `
+		WITH lots AS (
+		  SELECT order_id, "type", remaining,
+		    sum(remaining) OVER (ORDER BY create_time, order_id) - remaining AS before
+		  FROM debet_credit
+		  WHERE user_id = $1 AND ` + lotType + ` AND remaining > 0 AND ` + lotAlive + `
+		), consumed AS (
+		  UPDATE debet_credit
+		  SET remaining = debet_credit.remaining - LEAST(lots.remaining, $2 - lots.before)
+		  FROM lots
+		  WHERE debet_credit.order_id = lots.order_id AND debet_credit."type" = lots."type"
+		    AND lots.before < $2
+		  RETURNING debet_credit.expire_at
+		)
+		SELECT min(expire_at) FROM consumed
+	`
+	var expireAt sql.NullTime
+	err := tx.QueryRowContext(ctx, query, userID, sum).Scan(&expireAt)
+	if err != nil {
+		return expireAt, fmt.Errorf("failed consume debet_credit: %w", err)
+	}
+	return expireAt, nil
+}
+
+// ExpirePoints списывает остатки просроченных начислений записями EXPIRE,
+// не больше limit начислений за раз. Возвращает сколько начислений сгорело
+func (s *storage) ExpirePoints(ctx context.Context, limit uint) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed transaction in ExpirePoints: %w", err)
+	}
+	defer tx.Rollback()
+
+	// начисления пользователя, который сейчас списывает, пропускаем до следующего раза.
+	// EXPIRE у каждой партии свой: у партий разных пользователей может быть один order_id
+	query := `
+		WITH lots AS (
+		  SELECT order_id, "type", user_id, remaining FROM debet_credit
+		    WHERE ` + lotType + ` AND remaining > 0 AND NOT ` + lotAlive + `
+		    ORDER BY expire_at
+		    FOR UPDATE SKIP LOCKED
+		    LIMIT $1
+		), consumed AS (
+		  UPDATE debet_credit SET remaining = 0
+		  FROM lots
+		  WHERE debet_credit.order_id = lots.order_id AND debet_credit."type" = lots."type"
+		), inserted AS (
+		  INSERT INTO debet_credit (order_id, type, user_id, sum, source_order_id, source_type)
+		  SELECT gen_random_uuid()::text, 'EXPIRE', user_id, remaining, order_id, "type" FROM lots
+		)
+		SELECT order_id, user_id, remaining FROM lots
+	`
+	rows, err := tx.QueryContext(ctx, query, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed expire debet_credit: %w", err)
+	}
+	defer rows.Close()
+
+	expired := make(map[models.UserID][]models.Event)
+	count := 0
+	for rows.Next() {
+		var (
+			orderID models.OrderID
+			userID  models.UserID
+			sum     models.Money
+		)
+		if err := rows.Scan(&orderID, &userID, &sum); err != nil {
+			return 0, fmt.Errorf("failed scan expired debet_credit: %w", err)
+		}
+		expired[userID] = append(expired[userID], models.ExpireEvent(orderID, sum))
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed expire debet_credit: %w", err)
+	}
+	rows.Close()
+
+	for userID, events := range expired {
+		if err := publishEvents(ctx, tx, userID, events); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed commit ExpirePoints: %w", err)
+	}
+	return count, nil
+}
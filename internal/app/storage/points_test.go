@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// creditLot начисляет sum за новый заказ, ttl=0 - баллы не сгорают
+func creditLot(t *testing.T, s Storager, userID models.UserID, orderID models.OrderID, sum models.Money, ttl time.Duration) {
+	t.Helper()
+	ctx := context.Background()
+	require.NoError(t, s.CreateOrder(ctx, orderID, userID))
+	err := s.UpdateOrders(ctx, []*models.AccrualOrderItem{{
+		OrderID:   orderID,
+		UserID:    userID,
+		Status:    models.AccrualOrderProcessed,
+		Accrual:   &sum,
+		PointsTTL: ttl,
+	}}, "test")
+	require.NoError(t, err)
+}
+
+func TestMemExpirePointsFIFO(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStorage()
+	userID, err := s.CreateUser(ctx, "user", "hash")
+	require.NoError(t, err)
+	balance := func() *models.Balance {
+		b, err := s.Balance(ctx, *userID, time.Hour)
+		require.NoError(t, err)
+		return b
+	}
+
+	ttl := 50 * time.Millisecond
+	creditLot(t, s, *userID, "12345678903", 100*models.MoneyScale, ttl)
+	creditLot(t, s, *userID, "2377225624", 200*models.MoneyScale, 0)
+
+	// списание идет с самого старого начисления
+	require.NoError(t, s.Withdraw(ctx, *userID, "125", 60*models.MoneyScale))
+	b := balance()
+	assert.Equal(t, 240*models.MoneyScale, b.Current)
+	assert.Equal(t, 60*models.MoneyScale, b.Withdrawn)
+	require.Len(t, b.Expiring, 1)
+	assert.Equal(t, 40*models.MoneyScale, b.Expiring[0].Sum)
+
+	time.Sleep(2 * ttl)
+	// срок прошел: остаток уже не доступен, даже до ExpirePoints
+	b = balance()
+	assert.Equal(t, 200*models.MoneyScale, b.Current)
+	assert.Equal(t, 40*models.MoneyScale, b.Expired)
+	assert.Empty(t, b.Expiring)
+	assert.ErrorIs(t, s.Withdraw(ctx, *userID, "190", 200*models.MoneyScale+1), ErrNotEnoughMoney)
+
+	count, err := s.ExpirePoints(ctx, 100)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	count, err = s.ExpirePoints(ctx, 100)
+	require.NoError(t, err)
+	assert.Zero(t, count)
+	b = balance()
+	assert.Equal(t, 200*models.MoneyScale, b.Current)
+	assert.Equal(t, 40*models.MoneyScale, b.Expired)
+	assert.Equal(t, 60*models.MoneyScale, b.Withdrawn)
+
+	require.NoError(t, s.Withdraw(ctx, *userID, "190", 200*models.MoneyScale))
+	b = balance()
+	assert.Equal(t, models.Money(0), b.Current)
+	assert.Equal(t, 260*models.MoneyScale, b.Withdrawn)
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+)
+
+var ErrWithdrawalNotFound = errors.New("withdrawal not found")
+var ErrWithdrawalReversed = errors.New("withdrawal already reversed")
+
+// ReverseWithdrawal отменяет списание за заказ reversal.OrderID: баллы возвращаются
+// пользователю записью REVERSAL со сроком сгорания списанных баллов.
+// Заполняет UserID, Sum и CreatedAt
+func (s *storage) ReverseWithdrawal(ctx context.Context, reversal *models.Reversal) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// блокировка списания не дает двум отменам пройти одновременно
+	query := `
+		SELECT user_id, "sum", expire_at
+		FROM debet_credit
+		WHERE order_id = $1 AND "type" = 'CREDIT'
+		FOR UPDATE`
+	var expireAt sql.NullTime
+	err = tx.QueryRowContext(ctx, query, reversal.OrderID).Scan(&reversal.UserID, &reversal.Sum, &expireAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrWithdrawalNotFound
+		}
+		return fmt.Errorf("failed select withdrawal: %w", err)
+	}
+
+	query = `
+		INSERT INTO withdrawal_reversals (order_id, user_id, sum, reversed_by, reason)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING created_at`
+	err = tx.QueryRowContext(ctx, query, reversal.OrderID, reversal.UserID, reversal.Sum,
+		reversal.ReversedBy, reversal.Reason).Scan(&reversal.CreatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return ErrWithdrawalReversed
+		}
+		return fmt.Errorf("failed insert withdrawal_reversals: %w", err)
+	}
+	query = `
+		INSERT INTO debet_credit (order_id, type, user_id, sum, remaining, expire_at)
+		VALUES ($1, $2, $3, $4, $4, $5)`
+	_, err = tx.ExecContext(ctx, query, reversal.OrderID, models.CreditReversal, reversal.UserID, reversal.Sum, expireAt)
+	if err != nil {
+		return fmt.Errorf("failed insert debet_credit: %w", err)
+	}
+	err = publishEvents(ctx, tx, reversal.UserID, []models.Event{models.ReversalEvent(reversal.OrderID, reversal.Sum)})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed commit ReverseWithdrawal: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemReverseWithdrawal(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStorage()
+	userID, err := s.CreateUser(ctx, "user", "hash")
+	require.NoError(t, err)
+	creditLot(t, s, *userID, "12345678903", 100*models.MoneyScale, 0)
+	require.NoError(t, s.Withdraw(ctx, *userID, "2377225624", 70*models.MoneyScale))
+
+	err = s.ReverseWithdrawal(ctx, &models.Reversal{OrderID: "125", ReversedBy: "admin"})
+	assert.ErrorIs(t, err, ErrWithdrawalNotFound)
+
+	reversal := &models.Reversal{OrderID: "2377225624", ReversedBy: "admin", Reason: "order canceled"}
+	require.NoError(t, s.ReverseWithdrawal(ctx, reversal))
+	assert.Equal(t, *userID, reversal.UserID)
+	assert.Equal(t, 70*models.MoneyScale, reversal.Sum)
+	err = s.ReverseWithdrawal(ctx, &models.Reversal{OrderID: "2377225624", ReversedBy: "admin"})
+	assert.ErrorIs(t, err, ErrWithdrawalReversed)
+
+	b, err := s.Balance(ctx, *userID, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 100*models.MoneyScale, b.Current)
+	assert.Equal(t, models.Money(0), b.Withdrawn)
+
+	withdrawals, err := s.Withdrawals(ctx, *userID, &models.ListFilter{})
+	require.NoError(t, err)
+	require.Len(t, withdrawals, 1)
+	assert.True(t, withdrawals[0].Reversed)
+	require.NotNil(t, withdrawals[0].ReversedAt)
+
+	// возвращенные баллы можно потратить снова, номер заказа тот же занят
+	assert.ErrorIs(t, s.Withdraw(ctx, *userID, "2377225624", 10*models.MoneyScale), ErrOrderWithdrawnExists)
+	require.NoError(t, s.Withdraw(ctx, *userID, "190", 100*models.MoneyScale))
+}
@@ -0,0 +1,26 @@
+package storage
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// SchemaVersion должна совпадать с последней миграцией в репозитории
+func TestSchemaVersion(t *testing.T) {
+	files, err := filepath.Glob("../../../migrations/*.up.sql")
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+	var latest uint64
+	for _, file := range files {
+		num, _, _ := strings.Cut(filepath.Base(file), "_")
+		version, err := strconv.ParseUint(num, 10, 64)
+		require.NoError(t, err, file)
+		latest = max(latest, version)
+	}
+	assert.Equal(t, uint64(SchemaVersion), latest)
+}
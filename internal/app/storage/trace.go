@@ -0,0 +1,15 @@
+package storage
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// traceParent W3C traceparent текущего спана. Сохраняем вместе с заказом,
+// чтобы фоновая обработка ссылалась на запрос, который его создал
+func traceParent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+)
+
+var ErrRecipientNotFound = errors.New("recipient not found")
+var ErrSelfTransfer = errors.New("transfer to yourself")
+var ErrTransferLimitExceeded = errors.New("daily transfer limit exceeded")
+
+// Transfer переводит sum баллов пользователю с логином toLogin.
+// Списание у отправителя и начисление получателю - одна транзакция.
+// Полученные баллы сгорают не позже самых ранних из списанных.
+// dailyLimit - сколько можно перевести за последние сутки, 0 - без ограничения
+func (s *storage) Transfer(ctx context.Context, fromUserID models.UserID, toLogin string, sum, dailyLimit models.Money) (*models.Transfer, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var toUserID models.UserID
+	err = tx.QueryRowContext(ctx, "SELECT user_id FROM users WHERE login = $1", toLogin).Scan(&toUserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecipientNotFound
+		}
+		return nil, fmt.Errorf("failed select recipient: %w", err)
+	}
+	if toUserID == fromUserID {
+		return nil, ErrSelfTransfer
+	}
+	var fromLogin string
+	err = tx.QueryRowContext(ctx, "SELECT login FROM users WHERE user_id = $1", fromUserID).Scan(&fromLogin)
+	if err != nil {
+		return nil, fmt.Errorf("failed select sender: %w", err)
+	}
+
+	// блокируем только записи отправителя: получателю лишь добавляется новая партия
+	if err := lockBalance(ctx, tx, fromUserID); err != nil {
+		return nil, err
+	}
+	if dailyLimit > 0 {
+		query := `
+			SELECT coalesce(sum("sum"), 0)
+			FROM debet_credit
+			WHERE user_id = $1 AND "type" = 'TRANSFER_OUT'
+			  AND create_time > current_timestamp - interval '1 day'`
+		var sent models.Money
+		if err := tx.QueryRowContext(ctx, query, fromUserID).Scan(&sent); err != nil {
+			return nil, fmt.Errorf("failed select sent transfers: %w", err)
+		}
+		if sent+sum > dailyLimit {
+			return nil, ErrTransferLimitExceeded
+		}
+	}
+	available, err := availablePoints(ctx, tx, fromUserID)
+	if err != nil {
+		return nil, err
+	}
+	if available < sum {
+		return nil, ErrNotEnoughMoney
+	}
+	expireAt, err := consumeLots(ctx, tx, fromUserID, sum)
+	if err != nil {
+		return nil, err
+	}
+
+	transfer := models.Transfer{
+		ID:           uuid.New(),
+		Direction:    models.TransferSent,
+		Counterparty: toLogin,
+		Sum:          sum,
+	}
+	query := `
+		INSERT INTO transfers (id, from_user_id, to_user_id, sum)
+		VALUES ($1, $2, $3, $4)
+		RETURNING created_at`
+	err = tx.QueryRowContext(ctx, query, transfer.ID, fromUserID, toUserID, sum).Scan(&transfer.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed insert transfers: %w", err)
+	}
+	query = `
+		INSERT INTO debet_credit (order_id, type, user_id, sum)
+		VALUES ($1, $2, $3, $4)`
+	_, err = tx.ExecContext(ctx, query, transfer.ID.String(), models.TransferOut, fromUserID, sum)
+	if err != nil {
+		return nil, fmt.Errorf("failed insert debet_credit: %w", err)
+	}
+	query = `
+		INSERT INTO debet_credit (order_id, type, user_id, sum, remaining, expire_at)
+		VALUES ($1, $2, $3, $4, $4, $5)`
+	_, err = tx.ExecContext(ctx, query, transfer.ID.String(), models.TransferIn, toUserID, sum, expireAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed insert debet_credit: %w", err)
+	}
+
+	if err := publishEvents(ctx, tx, fromUserID, []models.Event{models.TransferEvent(&transfer)}); err != nil {
+		return nil, err
+	}
+	received := transfer
+	received.Direction = models.TransferReceived
+	received.Counterparty = fromLogin
+	if err := publishEvents(ctx, tx, toUserID, []models.Event{models.TransferEvent(&received)}); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed commit Transfer: %w", err)
+	}
+	return &transfer, nil
+}
+
+// Transfers отправленные и полученные переводы пользователя
+func (s *storage) Transfers(ctx context.Context, userID models.UserID, filter *models.ListFilter) (models.Transfers, error) {
+	query, args := listQuery(`
+		SELECT t.id, CASE WHEN t.from_user_id = $1 THEN 'out' ELSE 'in' END, u.login, t.sum, t.created_at
+		FROM transfers AS t
+		JOIN users AS u
+		  ON u.user_id = CASE WHEN t.from_user_id = $1 THEN t.to_user_id ELSE t.from_user_id END
+		WHERE (t.from_user_id = $1 OR t.to_user_id = $1)`,
+		[]any{userID}, filter, "t.created_at", "t.id::text", "",
+	)
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed Transfers: %w", err)
+	}
+	defer rows.Close()
+
+	transfers := make(models.Transfers, 0, 10)
+	for rows.Next() {
+		var item models.Transfer
+		err := rows.Scan(&item.ID, &item.Direction, &item.Counterparty, &item.Sum, &item.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed Scan in Transfers: %w", err)
+		}
+		transfers = append(transfers, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed Transfers: %w", err)
+	}
+	return transfers, nil
+}
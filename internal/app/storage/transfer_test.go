@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemTransfer(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemStorage()
+	from, err := s.CreateUser(ctx, "from", "hash")
+	require.NoError(t, err)
+	to, err := s.CreateUser(ctx, "to", "hash")
+	require.NoError(t, err)
+	balance := func(userID models.UserID) *models.Balance {
+		b, err := s.Balance(ctx, userID, 2*time.Hour)
+		require.NoError(t, err)
+		return b
+	}
+	creditLot(t, s, *from, "12345678903", 100*models.MoneyScale, time.Hour)
+	limit := 50 * models.MoneyScale
+
+	_, err = s.Transfer(ctx, *from, "nobody", 10*models.MoneyScale, limit)
+	assert.ErrorIs(t, err, ErrRecipientNotFound)
+	_, err = s.Transfer(ctx, *from, "from", 10*models.MoneyScale, limit)
+	assert.ErrorIs(t, err, ErrSelfTransfer)
+	_, err = s.Transfer(ctx, *to, "from", 10*models.MoneyScale, limit)
+	assert.ErrorIs(t, err, ErrNotEnoughMoney)
+
+	sent, err := s.Transfer(ctx, *from, "to", 30*models.MoneyScale, limit)
+	require.NoError(t, err)
+	assert.Equal(t, models.TransferSent, sent.Direction)
+	assert.Equal(t, "to", sent.Counterparty)
+	assert.Equal(t, 30*models.MoneyScale, sent.Sum)
+
+	// лимит считается по сумме переводов за сутки
+	_, err = s.Transfer(ctx, *from, "to", 30*models.MoneyScale, limit)
+	assert.ErrorIs(t, err, ErrTransferLimitExceeded)
+	_, err = s.Transfer(ctx, *from, "to", 20*models.MoneyScale, limit)
+	require.NoError(t, err)
+
+	b := balance(*from)
+	assert.Equal(t, 50*models.MoneyScale, b.Current)
+	assert.Equal(t, models.Money(0), b.Withdrawn)
+	// полученные баллы сгорают в срок исходного начисления
+	b = balance(*to)
+	assert.Equal(t, 50*models.MoneyScale, b.Current)
+	require.Len(t, b.Expiring, 2)
+	assert.Equal(t, 50*models.MoneyScale, b.Expiring[0].Sum+b.Expiring[1].Sum)
+	assert.Equal(t, b.Expiring[0].ExpireAt, b.Expiring[1].ExpireAt)
+	assert.Equal(t, balance(*from).Expiring[0].ExpireAt, b.Expiring[0].ExpireAt)
+
+	require.NoError(t, s.Withdraw(ctx, *to, "2377225624", 50*models.MoneyScale))
+	assert.Equal(t, models.Money(0), balance(*to).Current)
+
+	received, err := s.Transfers(ctx, *to, &models.ListFilter{})
+	require.NoError(t, err)
+	require.Len(t, received, 2)
+	for _, item := range received {
+		assert.Equal(t, models.TransferReceived, item.Direction)
+		assert.Equal(t, "from", item.Counterparty)
+	}
+	// новые первыми
+	assert.Equal(t, 20*models.MoneyScale, received[0].Sum)
+	assert.Equal(t, 30*models.MoneyScale, received[1].Sum)
+	history, err := s.Transfers(ctx, *from, &models.ListFilter{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, models.TransferSent, history[0].Direction)
+}
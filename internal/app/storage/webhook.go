@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+)
+
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// SetWebhook создает или заменяет вебхук пользователя вместе с ключом подписи
+func (s *storage) SetWebhook(ctx context.Context, hook *models.Webhook) error {
+	query := `
+	INSERT INTO webhooks (user_id, url, secret)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (user_id) DO UPDATE SET
+	url = EXCLUDED.url,
+	secret = EXCLUDED.secret,
+	created_at = current_timestamp
+	RETURNING created_at`
+	err := s.db.QueryRowContext(ctx, query, hook.UserID, hook.URL, hook.Secret).Scan(&hook.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed SetWebhook: %w", err)
+	}
+	return nil
+}
+
+func (s *storage) GetWebhook(ctx context.Context, userID models.UserID) (*models.Webhook, error) {
+	query := "SELECT url, created_at FROM webhooks WHERE user_id = $1"
+	hook := models.Webhook{UserID: userID}
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(&hook.URL, &hook.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, fmt.Errorf("failed GetWebhook: %w", err)
+	}
+	return &hook, nil
+}
+
+// DeleteWebhook удаляет вебхук. Недоставленные уведомления отправлять больше некуда
+func (s *storage) DeleteWebhook(ctx context.Context, userID models.UserID) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM webhooks WHERE user_id = $1", userID)
+	if err != nil {
+		return fmt.Errorf("failed delete webhook: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed delete webhook: %w", err)
+	}
+	if n == 0 {
+		return ErrWebhookNotFound
+	}
+
+	query := `
+	UPDATE webhook_outbox
+	SET status = 'FAILED', last_error = 'webhook deleted', who_lock = NULL, locked_at = NULL
+	WHERE user_id = $1 AND status = 'PENDING'`
+	_, err = tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return fmt.Errorf("failed cancel webhook deliveries: %w", err)
+	}
+	return tx.Commit()
+}
+
+func (s *storage) WebhookDeliveries(ctx context.Context, userID models.UserID, filter *models.ListFilter) (models.WebhookDeliveries, error) {
+	// курсор строковый, поэтому и сравниваем id как строки
+	query, args := listQuery(`
+		SELECT id, event, payload, status, attempts, last_status_code, last_error, created_at, delivered_at
+		FROM webhook_outbox
+		WHERE user_id = $1`,
+		[]any{userID}, filter, "created_at", "id::text", "",
+	)
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed WebhookDeliveries: %w", err)
+	}
+	defer rows.Close()
+
+	result := make(models.WebhookDeliveries, 0, 10)
+	for rows.Next() {
+		var (
+			item        models.WebhookDelivery
+			payload     []byte
+			statusCode  sql.NullInt32
+			lastError   sql.NullString
+			deliveredAt sql.NullTime
+		)
+		err := rows.Scan(&item.ID, &item.Event, &payload, &item.Status, &item.Attempts,
+			&statusCode, &lastError, &item.CreatedAt, &deliveredAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed Scan in WebhookDeliveries: %w", err)
+		}
+		item.UserID = userID
+		item.Payload = json.RawMessage(payload)
+		item.LastStatusCode = int(statusCode.Int32)
+		item.LastError = lastError.String
+		if deliveredAt.Valid {
+			item.DeliveredAt = &deliveredAt.Time
+		}
+		result = append(result, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed WebhookDeliveries: %w", err)
+	}
+	return result, nil
+}
+
+// ClaimWebhookDeliveries блокирует за who уведомления, которые пора отправить
+func (s *storage) ClaimWebhookDeliveries(ctx context.Context, who string, limit uint) (models.WebhookDeliveries, error) {
+	query := `
+		WITH wo AS (
+		  SELECT o.id FROM webhook_outbox AS o
+		    WHERE o.status = 'PENDING' AND o.who_lock IS NULL
+		      AND o.next_attempt_at <= current_timestamp
+		    ORDER BY o.next_attempt_at
+		    FOR UPDATE SKIP LOCKED
+		    LIMIT $1
+		)
+		UPDATE webhook_outbox
+		SET who_lock=$2, locked_at=current_timestamp
+		FROM wo, webhooks AS w
+		WHERE webhook_outbox.id = wo.id AND w.user_id = webhook_outbox.user_id
+		RETURNING webhook_outbox.id, webhook_outbox.user_id, webhook_outbox.event,
+		  webhook_outbox.payload, webhook_outbox.attempts, webhook_outbox.created_at,
+		  w.url, w.secret
+	`
+	rows, err := s.db.QueryContext(ctx, query, limit, who)
+	if err != nil {
+		return nil, fmt.Errorf("failed mark webhook_outbox: %w", err)
+	}
+	defer rows.Close()
+
+	result := make(models.WebhookDeliveries, 0, limit)
+	for rows.Next() {
+		var (
+			item    models.WebhookDelivery
+			payload []byte
+		)
+		err := rows.Scan(&item.ID, &item.UserID, &item.Event, &payload, &item.Attempts,
+			&item.CreatedAt, &item.URL, &item.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("failed scan webhook_outbox: %w", err)
+		}
+		item.Payload = json.RawMessage(payload)
+		item.Status = models.WebhookPending
+		result = append(result, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed next webhook_outbox: %w", err)
+	}
+	return result, nil
+}
+
+// FinishWebhookDeliveries сохраняет результаты попыток и снимает блокировку
+func (s *storage) FinishWebhookDeliveries(ctx context.Context, results []*models.WebhookResult, who string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed transaction in FinishWebhookDeliveries: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE webhook_outbox
+	SET who_lock=NULL, locked_at=NULL,
+	    attempts=$2, last_status_code=NULLIF($3, 0), last_error=NULLIF($4, ''),
+	    status=$5::webhook_delivery_status,
+	    next_attempt_at=current_timestamp + make_interval(secs => $6),
+	    delivered_at=CASE WHEN $7 THEN current_timestamp ELSE delivered_at END
+	WHERE id = $1 AND who_lock = $8`
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed prepare webhook_outbox: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, res := range results {
+		_, err := stmt.ExecContext(ctx, res.ID, res.Attempts, res.StatusCode, res.Error,
+			webhookStatus(res), res.NextAttemptIn.Seconds(), res.Delivered, who)
+		if err != nil {
+			return fmt.Errorf("failed exec webhook_outbox: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func webhookStatus(res *models.WebhookResult) models.WebhookDeliveryStatus {
+	switch {
+	case res.Delivered:
+		return models.WebhookDelivered
+	case res.Failed:
+		return models.WebhookFailed
+	}
+	return models.WebhookPending
+}
@@ -0,0 +1,308 @@
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+	"github.com/serg2014/go-musthave-diploma/internal/app/storage"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedStorage спан на каждый метод хранилища
+type tracedStorage struct {
+	next storage.Storager
+}
+
+// NewStorage оборачивает хранилище трассировкой
+func NewStorage(s storage.Storager) storage.Storager {
+	return &tracedStorage{next: s}
+}
+
+func start(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, "storage."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+func (s *tracedStorage) CreateUser(ctx context.Context, login, passwordHash string) (_ *models.UserID, err error) {
+	ctx, span := start(ctx, "CreateUser")
+	defer func() { End(span, err) }()
+	return s.next.CreateUser(ctx, login, passwordHash)
+}
+
+func (s *tracedStorage) GetUser(ctx context.Context, login string) (_ *storage.User, err error) {
+	ctx, span := start(ctx, "GetUser")
+	defer func() { End(span, err) }()
+	return s.next.GetUser(ctx, login)
+}
+
+func (s *tracedStorage) UpdateUserHash(ctx context.Context, userID models.UserID, passwordHash string) (err error) {
+	ctx, span := start(ctx, "UpdateUserHash")
+	defer func() { End(span, err) }()
+	return s.next.UpdateUserHash(ctx, userID, passwordHash)
+}
+
+func (s *tracedStorage) CreateSession(ctx context.Context, userID models.UserID, ttl time.Duration) (_ *models.Session, err error) {
+	ctx, span := start(ctx, "CreateSession")
+	defer func() { End(span, err) }()
+	return s.next.CreateSession(ctx, userID, ttl)
+}
+
+func (s *tracedStorage) GetActiveSession(ctx context.Context, sessionID models.SessionID) (_ *models.Session, err error) {
+	ctx, span := start(ctx, "GetActiveSession")
+	defer func() { End(span, err) }()
+	return s.next.GetActiveSession(ctx, sessionID)
+}
+
+func (s *tracedStorage) RevokeSession(ctx context.Context, sessionID models.SessionID) (err error) {
+	ctx, span := start(ctx, "RevokeSession")
+	defer func() { End(span, err) }()
+	return s.next.RevokeSession(ctx, sessionID)
+}
+
+func (s *tracedStorage) RevokeUserSessions(ctx context.Context, userID models.UserID) (err error) {
+	ctx, span := start(ctx, "RevokeUserSessions")
+	defer func() { End(span, err) }()
+	return s.next.RevokeUserSessions(ctx, userID)
+}
+
+func (s *tracedStorage) CreateOrder(ctx context.Context, orderID string, userID models.UserID) (err error) {
+	ctx, span := start(ctx, "CreateOrder", OrderAttr(orderID))
+	defer func() { End(span, err) }()
+	return s.next.CreateOrder(ctx, orderID, userID)
+}
+
+func (s *tracedStorage) GetUserOrders(ctx context.Context, userID models.UserID, filter *models.ListFilter) (_ models.Orders, err error) {
+	ctx, span := start(ctx, "GetUserOrders")
+	defer func() { End(span, err) }()
+	return s.next.GetUserOrders(ctx, userID, filter)
+}
+
+func (s *tracedStorage) Balance(ctx context.Context, userID models.UserID, expiringWithin time.Duration) (_ *models.Balance, err error) {
+	ctx, span := start(ctx, "Balance")
+	defer func() { End(span, err) }()
+	return s.next.Balance(ctx, userID, expiringWithin)
+}
+
+func (s *tracedStorage) Withdraw(ctx context.Context, userID models.UserID, orderID string, sum models.Money) (err error) {
+	ctx, span := start(ctx, "Withdraw", OrderAttr(orderID))
+	defer func() { End(span, err) }()
+	return s.next.Withdraw(ctx, userID, orderID, sum)
+}
+
+func (s *tracedStorage) Withdrawals(ctx context.Context, userID models.UserID, filter *models.ListFilter) (_ models.Withdrawals, err error) {
+	ctx, span := start(ctx, "Withdrawals")
+	defer func() { End(span, err) }()
+	return s.next.Withdrawals(ctx, userID, filter)
+}
+
+func (s *tracedStorage) CleanupAfterCrash(ctx context.Context, t time.Duration) (err error) {
+	ctx, span := start(ctx, "CleanupAfterCrash")
+	defer func() { End(span, err) }()
+	return s.next.CleanupAfterCrash(ctx, t)
+}
+
+func (s *tracedStorage) GetOrdersForProcess(ctx context.Context, who string, limit uint) (_ models.ProcessingOrders, err error) {
+	ctx, span := start(ctx, "GetOrdersForProcess")
+	defer func() { End(span, err) }()
+	data, err := s.next.GetOrdersForProcess(ctx, who, limit)
+	orderIDs := make([]string, 0, len(data))
+	for _, item := range data {
+		orderIDs = append(orderIDs, item.OrderID)
+	}
+	span.SetAttributes(attribute.StringSlice("order.ids", orderIDs))
+	return data, err
+}
+
+func (s *tracedStorage) UpdateOrders(ctx context.Context, data []*models.AccrualOrderItem, who string) (err error) {
+	ctx, span := start(ctx, "UpdateOrders", attribute.Int("orders.count", len(data)))
+	defer func() { End(span, err) }()
+	return s.next.UpdateOrders(ctx, data, who)
+}
+
+func (s *tracedStorage) CleanOrdersForProcess(ctx context.Context, who string) (err error) {
+	ctx, span := start(ctx, "CleanOrdersForProcess")
+	defer func() { End(span, err) }()
+	return s.next.CleanOrdersForProcess(ctx, who)
+}
+
+func (s *tracedStorage) ListDeadLetters(ctx context.Context, limit uint) (_ models.DeadLetters, err error) {
+	ctx, span := start(ctx, "ListDeadLetters")
+	defer func() { End(span, err) }()
+	return s.next.ListDeadLetters(ctx, limit)
+}
+
+func (s *tracedStorage) RequeueDeadLetter(ctx context.Context, orderID models.OrderID) (err error) {
+	ctx, span := start(ctx, "RequeueDeadLetter", OrderAttr(orderID))
+	defer func() { End(span, err) }()
+	return s.next.RequeueDeadLetter(ctx, orderID)
+}
+
+// ListenNewOrders долгоживущая подписка, спан не нужен
+func (s *tracedStorage) ListenNewOrders(ctx context.Context) (<-chan struct{}, error) {
+	return s.next.ListenNewOrders(ctx)
+}
+
+func (s *tracedStorage) QueueStats(ctx context.Context) (_ *models.QueueStats, err error) {
+	ctx, span := start(ctx, "QueueStats")
+	defer func() { End(span, err) }()
+	return s.next.QueueStats(ctx)
+}
+
+func (s *tracedStorage) Ping(ctx context.Context) (err error) {
+	ctx, span := start(ctx, "Ping")
+	defer func() { End(span, err) }()
+	return s.next.Ping(ctx)
+}
+
+func (s *tracedStorage) MigrationVersion(ctx context.Context) (_ uint, _ bool, err error) {
+	ctx, span := start(ctx, "MigrationVersion")
+	defer func() { End(span, err) }()
+	return s.next.MigrationVersion(ctx)
+}
+
+func (s *tracedStorage) BeginIdempotency(ctx context.Context, userID models.UserID, key, fingerprint string, ttl, lockTTL time.Duration) (_ *models.IdempotentResponse, err error) {
+	ctx, span := start(ctx, "BeginIdempotency")
+	defer func() { End(span, err) }()
+	return s.next.BeginIdempotency(ctx, userID, key, fingerprint, ttl, lockTTL)
+}
+
+func (s *tracedStorage) FinishIdempotency(ctx context.Context, userID models.UserID, key string, resp *models.IdempotentResponse) (err error) {
+	ctx, span := start(ctx, "FinishIdempotency")
+	defer func() { End(span, err) }()
+	return s.next.FinishIdempotency(ctx, userID, key, resp)
+}
+
+func (s *tracedStorage) DeleteIdempotency(ctx context.Context, userID models.UserID, key string) (err error) {
+	ctx, span := start(ctx, "DeleteIdempotency")
+	defer func() { End(span, err) }()
+	return s.next.DeleteIdempotency(ctx, userID, key)
+}
+
+func (s *tracedStorage) DeleteExpiredIdempotency(ctx context.Context) (err error) {
+	ctx, span := start(ctx, "DeleteExpiredIdempotency")
+	defer func() { End(span, err) }()
+	return s.next.DeleteExpiredIdempotency(ctx)
+}
+
+func (s *tracedStorage) SetWebhook(ctx context.Context, hook *models.Webhook) (err error) {
+	ctx, span := start(ctx, "SetWebhook")
+	defer func() { End(span, err) }()
+	return s.next.SetWebhook(ctx, hook)
+}
+
+func (s *tracedStorage) GetWebhook(ctx context.Context, userID models.UserID) (_ *models.Webhook, err error) {
+	ctx, span := start(ctx, "GetWebhook")
+	defer func() { End(span, err) }()
+	return s.next.GetWebhook(ctx, userID)
+}
+
+func (s *tracedStorage) DeleteWebhook(ctx context.Context, userID models.UserID) (err error) {
+	ctx, span := start(ctx, "DeleteWebhook")
+	defer func() { End(span, err) }()
+	return s.next.DeleteWebhook(ctx, userID)
+}
+
+func (s *tracedStorage) WebhookDeliveries(ctx context.Context, userID models.UserID, filter *models.ListFilter) (_ models.WebhookDeliveries, err error) {
+	ctx, span := start(ctx, "WebhookDeliveries")
+	defer func() { End(span, err) }()
+	return s.next.WebhookDeliveries(ctx, userID, filter)
+}
+
+func (s *tracedStorage) ClaimWebhookDeliveries(ctx context.Context, who string, limit uint) (_ models.WebhookDeliveries, err error) {
+	ctx, span := start(ctx, "ClaimWebhookDeliveries")
+	defer func() { End(span, err) }()
+	return s.next.ClaimWebhookDeliveries(ctx, who, limit)
+}
+
+func (s *tracedStorage) FinishWebhookDeliveries(ctx context.Context, results []*models.WebhookResult, who string) (err error) {
+	ctx, span := start(ctx, "FinishWebhookDeliveries")
+	defer func() { End(span, err) }()
+	return s.next.FinishWebhookDeliveries(ctx, results, who)
+}
+
+func (s *tracedStorage) UserEvents(ctx context.Context, userID models.UserID, afterID int64, limit uint) (_ models.UserEvents, err error) {
+	ctx, span := start(ctx, "UserEvents")
+	defer func() { End(span, err) }()
+	return s.next.UserEvents(ctx, userID, afterID, limit)
+}
+
+func (s *tracedStorage) LastUserEventID(ctx context.Context, userID models.UserID) (_ int64, err error) {
+	ctx, span := start(ctx, "LastUserEventID")
+	defer func() { End(span, err) }()
+	return s.next.LastUserEventID(ctx, userID)
+}
+
+// ListenUserEvents долгоживущая подписка, спан не нужен
+func (s *tracedStorage) ListenUserEvents(ctx context.Context) (<-chan models.UserID, error) {
+	return s.next.ListenUserEvents(ctx)
+}
+
+func (s *tracedStorage) DeleteExpiredUserEvents(ctx context.Context, ttl time.Duration) (err error) {
+	ctx, span := start(ctx, "DeleteExpiredUserEvents")
+	defer func() { End(span, err) }()
+	return s.next.DeleteExpiredUserEvents(ctx, ttl)
+}
+
+func (s *tracedStorage) ExpirePoints(ctx context.Context, limit uint) (_ int, err error) {
+	ctx, span := start(ctx, "ExpirePoints")
+	defer func() { End(span, err) }()
+	return s.next.ExpirePoints(ctx, limit)
+}
+
+func (s *tracedStorage) Transfer(ctx context.Context, fromUserID models.UserID, toLogin string, sum, dailyLimit models.Money) (_ *models.Transfer, err error) {
+	ctx, span := start(ctx, "Transfer")
+	defer func() { End(span, err) }()
+	return s.next.Transfer(ctx, fromUserID, toLogin, sum, dailyLimit)
+}
+
+func (s *tracedStorage) Transfers(ctx context.Context, userID models.UserID, filter *models.ListFilter) (_ models.Transfers, err error) {
+	ctx, span := start(ctx, "Transfers")
+	defer func() { End(span, err) }()
+	return s.next.Transfers(ctx, userID, filter)
+}
+
+func (s *tracedStorage) ReverseWithdrawal(ctx context.Context, reversal *models.Reversal) (err error) {
+	ctx, span := start(ctx, "ReverseWithdrawal")
+	defer func() { End(span, err) }()
+	return s.next.ReverseWithdrawal(ctx, reversal)
+}
+
+func (s *tracedStorage) HoldPoints(ctx context.Context, userID models.UserID, orderID models.OrderID, sum models.Money, ttl time.Duration) (_ *models.Hold, err error) {
+	ctx, span := start(ctx, "HoldPoints")
+	defer func() { End(span, err) }()
+	return s.next.HoldPoints(ctx, userID, orderID, sum, ttl)
+}
+
+func (s *tracedStorage) CaptureHold(ctx context.Context, orderID models.OrderID) (_ *models.Hold, err error) {
+	ctx, span := start(ctx, "CaptureHold")
+	defer func() { End(span, err) }()
+	return s.next.CaptureHold(ctx, orderID)
+}
+
+func (s *tracedStorage) ReleaseHold(ctx context.Context, orderID models.OrderID) (_ *models.Hold, err error) {
+	ctx, span := start(ctx, "ReleaseHold")
+	defer func() { End(span, err) }()
+	return s.next.ReleaseHold(ctx, orderID)
+}
+
+func (s *tracedStorage) ReleaseStaleHolds(ctx context.Context, limit uint) (_ int, err error) {
+	ctx, span := start(ctx, "ReleaseStaleHolds")
+	defer func() { End(span, err) }()
+	return s.next.ReleaseStaleHolds(ctx, limit)
+}
+
+func (s *tracedStorage) AdjustBalance(ctx context.Context, adj *models.Adjustment, pointsTTL time.Duration) (err error) {
+	ctx, span := start(ctx, "AdjustBalance")
+	defer func() { End(span, err) }()
+	return s.next.AdjustBalance(ctx, adj, pointsTTL)
+}
+
+func (s *tracedStorage) Adjustments(ctx context.Context, userID models.UserID, filter *models.ListFilter) (_ models.Adjustments, err error) {
+	ctx, span := start(ctx, "Adjustments")
+	defer func() { End(span, err) }()
+	return s.next.Adjustments(ctx, userID, filter)
+}
@@ -0,0 +1,87 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+
+	usercontext "github.com/serg2014/go-musthave-diploma/internal/app/context"
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+	"github.com/serg2014/go-musthave-diploma/internal/app/problem"
+	"github.com/serg2014/go-musthave-diploma/internal/logger"
+	"go.uber.org/zap"
+)
+
+// Transfer переводит баллы другому пользователю по логину.
+// Повторная отправка защищена обязательным Idempotency-Key
+func (a *App) Transfer() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := usercontext.GetUserID(r.Context())
+		if err != nil {
+			problem.Status(w, r, http.StatusUnauthorized, problem.CodeUnauthorized)
+			return
+		}
+
+		var req models.TransferRequest
+		dec := json.NewDecoder(r.Body)
+		if err := dec.Decode(&req); err != nil {
+			logger.Log.Debug("cannot decode request JSON body", zap.Error(err))
+			problem.Write(w, r, problem.New(http.StatusUnprocessableEntity, problem.CodeBadJSON, err.Error()))
+			return
+		}
+		if req.To == "" {
+			problem.Write(w, r, problem.New(http.StatusUnprocessableEntity, problem.CodeRecipientNotFound, "recipient login is required"))
+			return
+		}
+		if req.Sum < a.config.TransferMinSum {
+			problem.Write(w, r, problem.New(http.StatusUnprocessableEntity, problem.CodeInvalidSum,
+				"sum must be at least "+a.config.TransferMinSum.String()))
+			return
+		}
+		if a.config.TransferMaxSum > 0 && req.Sum > a.config.TransferMaxSum {
+			problem.Write(w, r, problem.New(http.StatusUnprocessableEntity, problem.CodeInvalidSum,
+				"sum must not exceed "+a.config.TransferMaxSum.String()))
+			return
+		}
+
+		transfer, err := a.store.Transfer(r.Context(), *userID, req.To, req.Sum, a.config.TransferDailyLimit)
+		if err != nil {
+			problem.Error(w, r, err, "failed Transfer")
+			return
+		}
+		logger.Log.Info("points transferred",
+			zap.String("transfer_id", transfer.ID.String()),
+			zap.String("user_id", userID.String()),
+			zap.Stringer("sum", transfer.Sum),
+		)
+		writeJSON(w, transfer)
+	}
+}
+
+// Transfers история отправленных и полученных переводов
+func (a *App) Transfers() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := usercontext.GetUserID(r.Context())
+		if err != nil {
+			problem.Status(w, r, http.StatusUnauthorized, problem.CodeUnauthorized)
+			return
+		}
+		filter, err := parseListFilter(r, false)
+		if err != nil {
+			problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeInvalidQuery, err.Error()))
+			return
+		}
+		data, err := a.store.Transfers(r.Context(), *userID, fetchLimit(filter))
+		if err != nil {
+			problem.Error(w, r, err, "failed Transfers")
+			return
+		}
+		data = setNextCursor(w, data, filter, func(t models.Transfer) models.Cursor {
+			return models.Cursor{Time: t.CreatedAt, ID: t.ID.String()}
+		})
+		if len(data) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, data)
+	}
+}
@@ -0,0 +1,351 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	usercontext "github.com/serg2014/go-musthave-diploma/internal/app/context"
+	"github.com/serg2014/go-musthave-diploma/internal/app/metrics"
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+	"github.com/serg2014/go-musthave-diploma/internal/app/problem"
+	"github.com/serg2014/go-musthave-diploma/internal/app/tracing"
+	"github.com/serg2014/go-musthave-diploma/internal/logger"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	webhookEventHeader    = "X-Gophermart-Event"
+	webhookDeliveryHeader = "X-Gophermart-Delivery"
+	// t=<unix time>,v1=<hex HMAC-SHA256 от "<unix time>.<body>">.
+	// Время в подписи защищает от повторной отправки старого тела
+	webhookSignatureHeader = "X-Gophermart-Signature"
+	// ответ получателя не нужен, читаем немного, чтобы переиспользовать соединение
+	webhookMaxResponse = 64 << 10
+)
+
+type webhookRequest struct {
+	URL string `json:"url"`
+}
+
+var errWebhookPrivateAddress = errors.New("url host must not resolve to a loopback, private or link-local address")
+
+func newWebhookClient() *http.Client {
+	// адрес проверяем при подключении, а не только при сохранении вебхука:
+	// DNS может начать отвечать внутренним адресом уже после проверки
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addr, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return fmt.Errorf("bad webhook address %q: %w", address, err)
+			}
+			if !publicWebhookAddr(addr.Addr()) {
+				return errWebhookPrivateAddress
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// через прокси проверка адреса при подключении не работает
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Transport: transport,
+		// редирект считаем неудачной попыткой, а не идем по чужому адресу
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// publicWebhookAddr вебхуки шлем только во внешнюю сеть: иначе пользователь
+// может отправлять подписанные запросы на внутренние сервисы
+func publicWebhookAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsValid() &&
+		!addr.IsLoopback() &&
+		!addr.IsPrivate() &&
+		!addr.IsLinkLocalUnicast() &&
+		!addr.IsLinkLocalMulticast() &&
+		!addr.IsInterfaceLocalMulticast() &&
+		!addr.IsMulticast() &&
+		!addr.IsUnspecified()
+}
+
+func validateWebhookURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return errors.New("bad url")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("url scheme must be http or https")
+	}
+	if u.Hostname() == "" {
+		return errors.New("url host is required")
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return errors.New("url host does not resolve")
+	}
+	for _, addr := range addrs {
+		if !publicWebhookAddr(addr) {
+			return errWebhookPrivateAddress
+		}
+	}
+	return nil
+}
+
+func generateWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed generate webhook secret: %w", err)
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// setWebhook создает или заменяет вебхук. Ключ подписи каждый раз новый,
+// отдаем его только в этом ответе
+func (a *App) setWebhook() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := usercontext.GetUserID(r.Context())
+		if err != nil {
+			problem.Status(w, r, http.StatusUnauthorized, problem.CodeUnauthorized)
+			return
+		}
+
+		var req webhookRequest
+		dec := json.NewDecoder(r.Body)
+		if err := dec.Decode(&req); err != nil {
+			problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeBadJSON, err.Error()))
+			return
+		}
+		if err := validateWebhookURL(r.Context(), req.URL); err != nil {
+			problem.Write(w, r, problem.New(http.StatusUnprocessableEntity, problem.CodeInvalidWebhookURL, err.Error()))
+			return
+		}
+
+		secret, err := generateWebhookSecret()
+		if err != nil {
+			problem.Error(w, r, err, "failed generate webhook secret")
+			return
+		}
+		hook := &models.Webhook{UserID: *userID, URL: req.URL, Secret: secret}
+		if err := a.store.SetWebhook(r.Context(), hook); err != nil {
+			problem.Error(w, r, err, "failed SetWebhook")
+			return
+		}
+		writeJSON(w, hook)
+	}
+}
+
+func (a *App) getWebhook() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := usercontext.GetUserID(r.Context())
+		if err != nil {
+			problem.Status(w, r, http.StatusUnauthorized, problem.CodeUnauthorized)
+			return
+		}
+		hook, err := a.store.GetWebhook(r.Context(), *userID)
+		if err != nil {
+			problem.Error(w, r, err, "failed GetWebhook")
+			return
+		}
+		writeJSON(w, hook)
+	}
+}
+
+func (a *App) deleteWebhook() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := usercontext.GetUserID(r.Context())
+		if err != nil {
+			problem.Status(w, r, http.StatusUnauthorized, problem.CodeUnauthorized)
+			return
+		}
+		if err := a.store.DeleteWebhook(r.Context(), *userID); err != nil {
+			problem.Error(w, r, err, "failed DeleteWebhook")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// webhookDeliveries журнал доставки уведомлений от новых к старым
+func (a *App) webhookDeliveries() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := usercontext.GetUserID(r.Context())
+		if err != nil {
+			problem.Status(w, r, http.StatusUnauthorized, problem.CodeUnauthorized)
+			return
+		}
+		filter, err := parseListFilter(r, false)
+		if err != nil {
+			problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeInvalidQuery, err.Error()))
+			return
+		}
+		data, err := a.store.WebhookDeliveries(r.Context(), *userID, fetchLimit(filter))
+		if err != nil {
+			problem.Error(w, r, err, "failed WebhookDeliveries")
+			return
+		}
+		data = setNextCursor(w, data, filter, func(d models.WebhookDelivery) models.Cursor {
+			return d.Cursor()
+		})
+		if len(data) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, data)
+	}
+}
+
+// DeliverWebhooks отправляет уведомления из outbox. Кроме тикера просыпается,
+// когда flusher сохранил результаты заказов
+func (a *App) DeliverWebhooks(ctx context.Context) {
+	ticker := time.NewTicker(a.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-a.webhookWake:
+		}
+		// полная пачка - возможно есть еще
+		for a.deliverWebhooks(ctx) {
+		}
+	}
+}
+
+func (a *App) deliverWebhooks(ctx context.Context) bool {
+	batch, err := a.store.ClaimWebhookDeliveries(ctx, a.who, uint(a.config.WebhookBatchSize))
+	if err != nil {
+		logger.Log.Error("failed ClaimWebhookDeliveries", zap.Error(err))
+		return false
+	}
+	if len(batch) == 0 {
+		return false
+	}
+
+	results := make([]*models.WebhookResult, len(batch))
+	var wg sync.WaitGroup
+	for i := range batch {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = a.sendWebhook(ctx, &batch[i])
+		}()
+	}
+	wg.Wait()
+
+	// блокировку снимаем и при остановке, иначе уведомления повиснут до CleanupAfterCrash
+	err = a.store.FinishWebhookDeliveries(context.WithoutCancel(ctx), results, a.who)
+	if err != nil {
+		logger.Log.Error("failed FinishWebhookDeliveries", zap.Error(err))
+		return false
+	}
+	return len(batch) == a.config.WebhookBatchSize && ctx.Err() == nil
+}
+
+func (a *App) sendWebhook(ctx context.Context, d *models.WebhookDelivery) *models.WebhookResult {
+	ctx, span := tracing.Tracer().Start(ctx, "DeliverWebhook", trace.WithAttributes(
+		attribute.Int64("webhook.delivery_id", d.ID),
+		attribute.String("webhook.event", string(d.Event)),
+		attribute.Int("webhook.attempts", d.Attempts),
+	))
+	defer span.End()
+
+	res := &models.WebhookResult{ID: d.ID, Attempts: d.Attempts}
+	statusCode, err := a.postWebhook(ctx, d)
+	res.StatusCode = statusCode
+	span.SetAttributes(attribute.Int("http.response.status_code", statusCode))
+	if err == nil {
+		res.Attempts++
+		res.Delivered = true
+		metrics.WebhookDelivery("delivered")
+		return res
+	}
+
+	span.RecordError(err)
+	res.Error = err.Error()
+	if ctx.Err() != nil {
+		// сервис останавливается, попытку не засчитываем
+		return res
+	}
+	res.Attempts++
+	if res.Attempts >= a.config.WebhookMaxAttempts {
+		res.Failed = true
+		metrics.WebhookDelivery("failed")
+		logger.Log.Warn(
+			"webhook delivery failed",
+			zap.Int64("delivery_id", d.ID),
+			zap.String("user_id", d.UserID.String()),
+			zap.Int("attempts", res.Attempts),
+			zap.Error(err),
+		)
+		return res
+	}
+	res.NextAttemptIn = backoff(res.Attempts, a.config.WebhookRetryBaseDelay, a.config.WebhookRetryMaxDelay)
+	metrics.WebhookDelivery("retry")
+	logger.Log.Debug(
+		"failed send webhook",
+		zap.Int64("delivery_id", d.ID),
+		zap.Int("attempts", res.Attempts),
+		zap.Duration("next_attempt_in", res.NextAttemptIn),
+		zap.Error(err),
+	)
+	return res
+}
+
+// postWebhook успех - только 2xx
+func (a *App) postWebhook(ctx context.Context, d *models.WebhookDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, a.config.WebhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("failed create webhook request: %w", err)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, string(d.Event))
+	req.Header.Set(webhookDeliveryHeader, strconv.FormatInt(d.ID, 10))
+	req.Header.Set(webhookSignatureHeader, "t="+timestamp+",v1="+signWebhook(d.Secret, timestamp, d.Payload))
+
+	resp, err := a.webhookClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed send webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, webhookMaxResponse))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
@@ -2,136 +2,178 @@ package app
 
 import (
 	"context"
-	"encoding/json"
-	"errors"
-	"fmt"
-	"net/http"
-	"os"
 	"time"
 
+	"github.com/serg2014/go-musthave-diploma/internal/app/metrics"
 	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+	"github.com/serg2014/go-musthave-diploma/internal/app/tracing"
 	"github.com/serg2014/go-musthave-diploma/internal/logger"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
-var (
-	ErrHTTPNoContent           = errors.New("http 204")
-	ErrHTTPNTooManyRequets     = errors.New("http 429")
-	ErrHTTPInternalServerError = errors.New("http 500")
-	ErrHTTPOther               = errors.New("http other")
-	ErrTimeout                 = errors.New("timeout")
-	ErrContext                 = errors.New("error context")
-	ErrDoneContext             = errors.New("done context")
-)
-
-func geturlWithRetries(ctx context.Context, client *http.Client, url string) (*models.AccrualOrderItem, error) {
-	retry := []time.Duration{
-		1500 * time.Millisecond,
-		3000 * time.Millisecond,
-		0 * time.Millisecond,
-	}
-
-	var (
-		data *models.AccrualOrderItem
-		err  error
-	)
-	for _, dur := range retry {
-		data, err = geturl(ctx, client, url)
-		if err == nil {
-			break
-		}
-		// TODO как поймать timeout?
-		// in geturl err: Get "http://localhost:8080/api/orders/32": context deadline exceeded (Client.Timeout exceeded while awaiting headers)
-		// data: <nil> error: failed get
-		// data: <nil> error: bad json: EOF - нет тела
-		// тут таймаут на получении тела
-		// data: <nil> error: bad json: context deadline exceeded (Client.Timeout or context cancellation while reading body)
-		if errors.Is(err, ErrTimeout) ||
-			errors.Is(err, ErrHTTPInternalServerError) ||
-			errors.Is(err, ErrHTTPNTooManyRequets) {
-			timeout := time.After(dur)
+func (a *App) worker(ctx context.Context, i int) {
+	for {
+		select {
+		case data := <-a.reqChan:
+			resp := a.getAccrual(ctx, data)
+			a.resChan <- resp
 			select {
-			case <-timeout:
-				continue
-			case <-ctx.Done():
-				return nil, ErrDoneContext
+			case a.wake <- struct{}{}:
+			default:
 			}
+		case <-ctx.Done():
+			logger.Log.Debug("Stop worker", zap.Int("num", i))
+			return
 		}
-		return nil, err
 	}
-
-	return data, err
 }
 
-func geturl(ctx context.Context, client *http.Client, url string) (*models.AccrualOrderItem, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		return nil, ErrContext
-	}
-	response, err := client.Do(req)
-	if err != nil {
-		if os.IsTimeout(err) {
-			return nil, ErrTimeout
-		}
-		return nil, fmt.Errorf("failed get: %w", err)
-	}
-	defer response.Body.Close()
+// getAccrual каждый заказ обрабатываем в своей трассе со ссылкой на запрос,
+// который поставил его в очередь
+func (a *App) getAccrual(ctx context.Context, item *models.ProcessingOrderItem) *models.AccrualOrderItem {
+	defer metrics.WorkerBusy()()
 
-	statusToError := map[int]error{
-		http.StatusOK:                  nil,
-		http.StatusNoContent:           ErrHTTPNoContent,
-		http.StatusTooManyRequests:     ErrHTTPNTooManyRequets,
-		http.StatusInternalServerError: ErrHTTPInternalServerError,
+	opts := []trace.SpanStartOption{
+		trace.WithNewRoot(),
+		trace.WithAttributes(
+			tracing.OrderAttr(item.OrderID),
+			attribute.Int("order.attempts", item.Attempts),
+		),
 	}
-	err, ok := statusToError[response.StatusCode]
-	if !ok {
-		err = ErrHTTPOther
+	if link, ok := tracing.LinkFromTraceParent(item.TraceParent); ok {
+		opts = append(opts, trace.WithLinks(link))
 	}
+	ctx, span := tracing.Tracer().Start(ctx, "ProcessOrder", opts...)
+	defer span.End()
+
+	data, err := a.accrual.GetAccrual(ctx, item.OrderID)
 	if err != nil {
-		return nil, err
+		data = &models.AccrualOrderItem{
+			OrderID: item.OrderID,
+			UserID:  item.UserID,
+			Error:   err,
+		}
 	}
+	// TODO может сделать чтобы GetAccrual возвращал UserID
+	data.UserID = item.UserID
+	data.PointsTTL = a.config.PointsTTL
+	a.scheduleRetry(item, data)
+	a.health.accrualResult(data.Error)
+	data.TraceParent = tracing.TraceParent(ctx)
 
-	data := models.AccrualOrderItem{}
-	dec := json.NewDecoder(response.Body)
-	if err := dec.Decode(&data); err != nil {
-		if os.IsTimeout(err) {
-			return nil, ErrTimeout
-		}
-		return nil, fmt.Errorf("bad json: %w", err)
+	if data.Error != nil {
+		span.RecordError(data.Error)
 	}
+	span.SetAttributes(
+		attribute.String("accrual.status", string(data.Status)),
+		attribute.Bool("order.dead", data.Dead),
+	)
 
-	return &data, nil
+	return data
 }
 
-func (a *App) worker(ctx context.Context, i int) {
+// flusher копит результаты и пишет их в хранилище, когда набралось
+// FlushBatchSize или прошло FlushInterval. Работает до закрытия resChan
+func (a *App) flusher() {
+	batch := make([]*models.AccrualOrderItem, 0, a.config.FlushBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		a.saveResults(batch)
+		// пачку не копим: что не сохранилось, уже отложено или ждет CleanOrdersForProcess
+		batch = batch[:0]
+		select {
+		case a.webhookWake <- struct{}{}:
+		default:
+		}
+	}
+
+	ticker := time.NewTicker(a.config.FlushInterval)
+	defer ticker.Stop()
 	for {
 		select {
-		case data := <-a.reqChan:
-			resp := a.getAccrual(data)
-			a.resChan <- resp
-		case <-ctx.Done():
-			logger.Log.Debug("Stop worker", zap.Int("num", i))
-			return
+		case item, ok := <-a.resChan:
+			if !ok {
+				flush()
+				return
+			}
+			logResult(item)
+			// ошибки тоже передаем, чтобы отложить следующую попытку
+			batch = append(batch, item)
+			if len(batch) >= a.config.FlushBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
 		}
 	}
 }
 
-func (a *App) getAccrual(item *models.ProcessingOrderItem) *models.AccrualOrderItem {
-	endpoint := fmt.Sprintf("%s/api/orders/%s", a.AccrualAddress(), item.OrderID)
-	client := &http.Client{
-		// TODO timeout в конфиг
-		Timeout: 5 * time.Second,
+// saveResults пишет пачку результатов. Если пачка не сохранилась, пишем по одному,
+// чтобы один плохой заказ (например, с уже сохраненным начислением) не держал остальные.
+// Заказы, которые так и не сохранились, откладываем как ошибку: блокировка снимается
+func (a *App) saveResults(batch []*models.AccrualOrderItem) {
+	err := a.updateOrders(batch)
+	if err == nil {
+		return
 	}
-	data, err := geturlWithRetries(context.Background(), client, endpoint)
-	if err != nil {
-		return &models.AccrualOrderItem{
-			OrderID: item.OrderID,
-			UserID:  item.UserID,
-			Error:   err,
+	logger.Log.Error("failed UpdateOrders", zap.Error(err), zap.Int("batch", len(batch)))
+
+	failed := make([]*models.AccrualOrderItem, 0, len(batch))
+	for _, item := range batch {
+		if len(batch) > 1 {
+			err = a.updateOrders([]*models.AccrualOrderItem{item})
+			if err == nil {
+				continue
+			}
 		}
+		logger.Log.Error("failed save order result", zap.Error(err), zap.String("orderID", item.OrderID))
+		failed = append(failed, a.saveFailed(item, err))
 	}
-	// TODO может сделать чтобы geturlWithRetries возвращал UserID
-	data.UserID = item.UserID
+	if len(failed) == 0 {
+		return
+	}
+	if err := a.updateOrders(failed); err != nil {
+		// хранилище недоступно: блокировки снимет CleanOrdersForProcess при остановке
+		// или CleanupAfterCrash при следующем запуске
+		logger.Log.Error("failed reschedule unsaved orders", zap.Error(err), zap.Int("orders", len(failed)))
+	}
+}
 
-	return data
+// updateOrders пачка связана со спанами обработки каждого заказа.
+// Контекст не отменяем: при остановке результаты все равно нужно сохранить
+func (a *App) updateOrders(batch []*models.AccrualOrderItem) error {
+	links := make([]trace.Link, 0, len(batch))
+	for _, item := range batch {
+		if link, ok := tracing.LinkFromTraceParent(item.TraceParent); ok {
+			links = append(links, link)
+		}
+	}
+	ctx, span := tracing.Tracer().Start(context.Background(), "FlushResults", trace.WithLinks(links...))
+	err := a.store.UpdateOrders(ctx, batch, a.who)
+	tracing.End(span, err)
+	return err
+}
+
+func logResult(item *models.AccrualOrderItem) {
+	if item.Error != nil {
+		logger.Log.Debug(
+			"failed get Accrual",
+			zap.Error(item.Error),
+			zap.String("orderID", item.OrderID),
+			zap.Int("attempts", item.Attempts),
+			zap.Duration("next_attempt_in", item.NextAttemptIn),
+		)
+	}
+	if item.Dead {
+		logger.Log.Warn(
+			"order moved to dead letter",
+			zap.String("orderID", item.OrderID),
+			zap.Int("attempts", item.Attempts),
+			zap.String("reason", item.FailReason()),
+		)
+	}
 }
@@ -0,0 +1,167 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/serg2014/go-musthave-diploma/internal/app/accrual"
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
+	"github.com/serg2014/go-musthave-diploma/internal/app/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runProcessOrders запускает обработчик заказов до конца теста
+func runProcessOrders(t *testing.T, a *App) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		a.ProcessOrders(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+}
+
+func TestProcessOrders(t *testing.T) {
+	acc := newFakeAccrual()
+	acc.set("12345678903", models.AccrualOrderProcessed, 729980)
+	acc.set("2377225624", models.AccrualOrderInvalid, 0)
+	a := newTestApp(t, acc)
+	server := httptest.NewServer(a.GetRouter())
+	defer server.Close()
+	c := newTestClient(t, server)
+	c.register("user")
+	runProcessOrders(t, a)
+
+	for _, order := range []string{"12345678903", "2377225624", "125"} {
+		status, body := c.do(http.MethodPost, "/api/user/orders", "text/plain", order)
+		require.Equal(t, http.StatusAccepted, status, body)
+	}
+
+	userID := testUserID(t, a, "user")
+	statuses := func() map[models.OrderID]models.OrderStatus {
+		orders, err := a.store.GetUserOrders(context.Background(), userID, &models.ListFilter{})
+		require.NoError(t, err)
+		result := make(map[models.OrderID]models.OrderStatus, len(orders))
+		for _, order := range orders {
+			result[order.OrderID] = order.Status
+		}
+		return result
+	}
+	require.Eventually(t, func() bool {
+		s := statuses()
+		return s["12345678903"] == models.OrderProcessed && s["2377225624"] == models.OrderInvalid
+	}, 10*time.Second, 50*time.Millisecond)
+	// заказ, которого нет в accrual, ждет следующей попытки
+	assert.Equal(t, models.OrderNew, statuses()["125"])
+
+	balance, err := a.store.Balance(context.Background(), userID, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, models.Money(729980), balance.Current)
+}
+
+func TestSaveResultsSkipsFailedOrder(t *testing.T) {
+	a := newTestApp(t, newFakeAccrual())
+	ctx := context.Background()
+	userID, err := a.store.CreateUser(ctx, "user", "hash")
+	require.NoError(t, err)
+
+	// начисление за первый заказ уже сохранено, второй заказ в обработке у нас
+	credit(t, a, "user", "12345678903", 100*models.MoneyScale)
+	require.NoError(t, a.store.CreateOrder(ctx, "2377225624", *userID))
+	orders, err := a.store.GetOrdersForProcess(ctx, a.who, 10)
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+
+	first := 100 * models.MoneyScale
+	sum := 50 * models.MoneyScale
+	a.saveResults([]*models.AccrualOrderItem{
+		{OrderID: "12345678903", UserID: *userID, Status: models.AccrualOrderProcessed, Accrual: &first},
+		{OrderID: "2377225624", UserID: *userID, Status: models.AccrualOrderProcessed, Accrual: &sum},
+	})
+
+	// повторное начисление не сохранилось, но и не помешало второму заказу
+	balance, err := a.store.Balance(ctx, *userID, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 150*models.MoneyScale, balance.Current)
+	stats, err := a.store.QueueStats(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, stats.Total)
+}
+
+// failingStore не сохраняет результаты заказа failOrder, кроме отложенных после сбоя
+type failingStore struct {
+	storage.Storager
+	failOrder models.OrderID
+}
+
+func (s *failingStore) UpdateOrders(ctx context.Context, data []*models.AccrualOrderItem, who string) error {
+	for _, item := range data {
+		if item.OrderID == s.failOrder && !errors.Is(item.Error, errSaveResult) {
+			return errors.New("db is down")
+		}
+	}
+	return s.Storager.UpdateOrders(ctx, data, who)
+}
+
+func TestSaveResultsKeepsAttempts(t *testing.T) {
+	sum := 10 * models.MoneyScale
+	tests := []struct {
+		name   string
+		result models.AccrualOrderItem
+	}{
+		{"processed", models.AccrualOrderItem{Status: models.AccrualOrderProcessed, Accrual: &sum}},
+		{"not registered", models.AccrualOrderItem{Error: accrual.ErrHTTPNoContent}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApp(t, newFakeAccrual())
+			a.config.RetryBaseDelay = time.Millisecond
+			a.config.RetryMaxDelay = time.Millisecond
+			store := &failingStore{Storager: a.store}
+			a.store = store
+			ctx := context.Background()
+			userID, err := a.store.CreateUser(ctx, "user", "hash")
+			require.NoError(t, err)
+			require.NoError(t, a.store.CreateOrder(ctx, "12345678903", *userID))
+
+			// claim ждет, пока заказ снова можно взять в обработку
+			claim := func() *models.ProcessingOrderItem {
+				var orders models.ProcessingOrders
+				require.Eventually(t, func() bool {
+					orders, err = a.store.GetOrdersForProcess(ctx, a.who, 10)
+					require.NoError(t, err)
+					return len(orders) == 1
+				}, time.Second, 5*time.Millisecond)
+				return &orders[0]
+			}
+
+			// первый раунд: accrual не знает заказ, попытка засчитана
+			item := claim()
+			res := &models.AccrualOrderItem{OrderID: item.OrderID, UserID: item.UserID, Error: accrual.ErrHTTPNoContent}
+			a.scheduleRetry(item, res)
+			a.saveResults([]*models.AccrualOrderItem{res})
+			item = claim()
+			require.Equal(t, 1, item.Attempts)
+
+			// второй раунд не сохранился: заказ вернется с числом попыток до этого раунда
+			store.failOrder = item.OrderID
+			res = &tt.result
+			res.OrderID, res.UserID = item.OrderID, item.UserID
+			a.scheduleRetry(item, res)
+			a.saveResults([]*models.AccrualOrderItem{res})
+			item = claim()
+			assert.Equal(t, 1, item.Attempts)
+		})
+	}
+}
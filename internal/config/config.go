@@ -6,16 +6,129 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"time"
 
 	"github.com/caarlos0/env/v11"
+	"github.com/serg2014/go-musthave-diploma/internal/app/models"
 )
 
+const (
+	StoragePostgres = "postgres"
+	StorageMemory   = "memory"
+)
+
+// DefaultPasswordSecret секрет, которым подписаны пароли до перехода на argon2id.
+// Без него пользователи со старыми хэшами не смогут войти и хэш не обновится
+const DefaultPasswordSecret = "somesecret"
+
+// DefaultLegacyCookieSecret секрет, которым подписаны cookie userid.signature
+// до перехода на сессии. Без него пользователям придется войти заново
+const DefaultLegacyCookieSecret = "newsomesecret"
+
 type Config struct {
 	Address        string `env:"RUN_ADDRESS"`
 	DatabaseDSN    string `env:"DATABASE_URI"`
 	AccrualAddress string `env:"ACCRUAL_SYSTEM_ADDRESS"`
+	StorageType    string `env:"STORAGE_TYPE"`
 	LogLevel       string
 	Port           uint16
+
+	// ключи подписи cookie в формате "id1:secret1,id2:secret2"
+	CookieKeys     string `env:"AUTH_COOKIE_KEYS"`
+	CookieKeysFile string `env:"AUTH_COOKIE_KEYS_FILE"`
+	// ключ для подписи новых cookie, по умолчанию первый
+	CookieKeyID string `env:"AUTH_COOKIE_KEY_ID"`
+	// секрет устаревших HMAC хэшей паролей
+	PasswordSecret     string `env:"AUTH_PASSWORD_SECRET"`
+	PasswordSecretFile string `env:"AUTH_PASSWORD_SECRET_FILE"`
+	// секрет cookie старого формата, пустой - старые cookie не принимаем
+	LegacyCookieSecret string `env:"AUTH_LEGACY_COOKIE_SECRET"`
+	// разобранные CookieKeys и CookieKeysFile
+	AuthKeys []AuthKey
+	// время жизни сессии
+	SessionTTL time.Duration `env:"AUTH_SESSION_TTL"`
+	// время жизни access токена (JWT)
+	AccessTokenTTL time.Duration `env:"AUTH_ACCESS_TOKEN_TTL"`
+
+	// таймаут одного запроса в accrual
+	AccrualTimeout time.Duration `env:"ACCRUAL_TIMEOUT"`
+	// после скольких ошибок подряд перестаем ходить в accrual
+	AccrualBreakerThreshold int `env:"ACCRUAL_BREAKER_THRESHOLD"`
+	// через сколько пробуем снова
+	AccrualBreakerCooldown time.Duration `env:"ACCRUAL_BREAKER_COOLDOWN"`
+	// задержка перед повторным запросом заказа растет от RetryBaseDelay до RetryMaxDelay
+	RetryBaseDelay time.Duration `env:"ACCRUAL_RETRY_BASE_DELAY"`
+	RetryMaxDelay  time.Duration `env:"ACCRUAL_RETRY_MAX_DELAY"`
+	// воркеров, которые ходят в accrual
+	Workers int `env:"ACCRUAL_WORKERS"`
+	// как часто забираем новые заказы в обработку
+	PollInterval time.Duration `env:"ORDERS_POLL_INTERVAL"`
+	// результаты пишем в хранилище пачками не больше FlushBatchSize и не реже FlushInterval
+	FlushBatchSize int           `env:"ORDERS_FLUSH_BATCH_SIZE"`
+	FlushInterval  time.Duration `env:"ORDERS_FLUSH_INTERVAL"`
+	// после стольких попыток или такого времени в обработке заказ уходит в dead letter
+	OrderMaxAttempts int           `env:"ORDER_MAX_ATTEMPTS"`
+	OrderMaxAge      time.Duration `env:"ORDER_MAX_AGE"`
+
+	// таймаут одного запроса на вебхук пользователя
+	WebhookTimeout time.Duration `env:"WEBHOOK_TIMEOUT"`
+	// сколько уведомлений отправляем одновременно
+	WebhookBatchSize int `env:"WEBHOOK_BATCH_SIZE"`
+	// после стольких неудачных попыток уведомление больше не отправляем
+	WebhookMaxAttempts int `env:"WEBHOOK_MAX_ATTEMPTS"`
+	// задержка перед повторной отправкой растет от WebhookRetryBaseDelay до WebhookRetryMaxDelay
+	WebhookRetryBaseDelay time.Duration `env:"WEBHOOK_RETRY_BASE_DELAY"`
+	WebhookRetryMaxDelay  time.Duration `env:"WEBHOOK_RETRY_MAX_DELAY"`
+
+	// через сколько сгорают начисленные баллы, 0 - не сгорают
+	PointsTTL time.Duration `env:"POINTS_TTL"`
+	// как часто списываем сгоревшие баллы
+	PointsExpireInterval time.Duration `env:"POINTS_EXPIRE_INTERVAL"`
+	// за сколько до сгорания показываем баллы в балансе
+	PointsExpiringWindow time.Duration `env:"POINTS_EXPIRING_WINDOW"`
+
+	// границы суммы одного перевода баллов, 0 в TransferMaxSum - без ограничения
+	TransferMinSum models.Money `env:"TRANSFER_MIN_SUM"`
+	TransferMaxSum models.Money `env:"TRANSFER_MAX_SUM"`
+	// сколько пользователь может перевести за сутки, 0 - без ограничения
+	TransferDailyLimit models.Money `env:"TRANSFER_DAILY_LIMIT"`
+
+	// через сколько неподтвержденный резерв баллов снимается
+	HoldTTL time.Duration `env:"HOLD_TTL"`
+	// как часто снимаем просроченные резервы
+	HoldReleaseInterval time.Duration `env:"HOLD_RELEASE_INTERVAL"`
+
+	// сколько хранятся события пользователя для продолжения SSE потока
+	EventsTTL time.Duration `env:"EVENTS_TTL"`
+	// как часто шлем в SSE поток комментарий, чтобы прокси не закрыли соединение
+	EventsHeartbeat time.Duration `env:"EVENTS_HEARTBEAT"`
+
+	// сколько хранится ответ на запрос с Idempotency-Key
+	IdempotencyTTL time.Duration `env:"IDEMPOTENCY_TTL"`
+	// сколько ключ занят выполняющимся запросом. Дольше запрос не выполняется,
+	// после этого повтор может занять ключ, если процесс упал
+	IdempotencyLockTTL time.Duration `env:"IDEMPOTENCY_LOCK_TTL"`
+
+	// обработчик заказов считается зависшим, если не было тика дольше
+	HealthMaxTickAge time.Duration `env:"HEALTH_MAX_TICK_AGE"`
+	// доля ошибок accrual среди последних запросов, после которой не готовы принимать трафик
+	HealthMaxAccrualErrorRate float64 `env:"HEALTH_MAX_ACCRUAL_ERROR_RATE"`
+	// сколько отвечаем readyz=false перед остановкой сервера, чтобы балансировщик успел заметить
+	ShutdownDrain time.Duration `env:"SHUTDOWN_DRAIN"`
+
+	// куда отправлять трассы: none, stdout или otlp
+	TraceExporter string `env:"TRACE_EXPORTER"`
+	// адрес OTLP/HTTP коллектора, например http://localhost:4318
+	TraceOTLPEndpoint string `env:"TRACE_OTLP_ENDPOINT"`
+
+	// токены администраторов в формате "name1:token1,name2:token2"
+	AdminTokens string `env:"ADMIN_TOKENS"`
+	// разобранные AdminTokens
+	Admins []AuthKey
+	// токены магазинов в том же формате, магазин может только отменять списания
+	MerchantTokens string `env:"MERCHANT_TOKENS"`
+	// разобранные MerchantTokens
+	Merchants []AuthKey
 }
 
 func NewConfig() (*Config, error) {
@@ -24,7 +137,51 @@ func NewConfig() (*Config, error) {
 	flag.StringVar(&cfg.Address, "a", "", "server address")
 	flag.StringVar(&cfg.DatabaseDSN, "d", "", "database dsn")
 	flag.StringVar(&cfg.AccrualAddress, "r", "", "accrual service address")
+	flag.StringVar(&cfg.StorageType, "s", StoragePostgres, "storage type: postgres or memory")
 	flag.StringVar(&cfg.LogLevel, "l", "debug", "log level")
+	flag.StringVar(&cfg.CookieKeys, "k", "", "cookie signing keys: id1:secret1,id2:secret2")
+	flag.StringVar(&cfg.CookieKeysFile, "kf", "", "file with cookie signing keys, one id:secret per line")
+	flag.StringVar(&cfg.CookieKeyID, "kid", "", "id of the key used to sign new cookies")
+	flag.StringVar(&cfg.PasswordSecret, "ps", DefaultPasswordSecret, "secret of legacy password hashes")
+	flag.StringVar(&cfg.PasswordSecretFile, "psf", "", "file with secret of legacy password hashes")
+	flag.StringVar(&cfg.LegacyCookieSecret, "legacy-cookie-secret", DefaultLegacyCookieSecret, "secret of legacy auth cookies, empty - reject them")
+	flag.DurationVar(&cfg.SessionTTL, "st", 24*time.Hour, "session ttl")
+	flag.DurationVar(&cfg.AccessTokenTTL, "access-ttl", 15*time.Minute, "access token ttl")
+	flag.DurationVar(&cfg.AccrualTimeout, "rt", 5*time.Second, "accrual request timeout")
+	flag.IntVar(&cfg.AccrualBreakerThreshold, "rbt", 5, "accrual failures in a row to open circuit breaker")
+	flag.DurationVar(&cfg.AccrualBreakerCooldown, "rbc", 30*time.Second, "accrual circuit breaker cooldown")
+	flag.DurationVar(&cfg.RetryBaseDelay, "rrb", time.Second, "base delay before next order check")
+	flag.DurationVar(&cfg.RetryMaxDelay, "rrm", 10*time.Minute, "max delay before next order check")
+	flag.IntVar(&cfg.Workers, "w", 10, "accrual workers count")
+	flag.DurationVar(&cfg.PollInterval, "pi", time.Second, "orders poll interval")
+	flag.IntVar(&cfg.FlushBatchSize, "fbs", 50, "max results in one storage update")
+	flag.DurationVar(&cfg.FlushInterval, "fi", 500*time.Millisecond, "max delay before results are saved")
+	flag.IntVar(&cfg.OrderMaxAttempts, "oma", 20, "max accrual attempts per order")
+	flag.DurationVar(&cfg.OrderMaxAge, "omg", 24*time.Hour, "max time of order processing")
+	flag.DurationVar(&cfg.WebhookTimeout, "wht", 5*time.Second, "webhook request timeout")
+	flag.IntVar(&cfg.WebhookBatchSize, "whn", 10, "webhooks sent at once")
+	flag.IntVar(&cfg.WebhookMaxAttempts, "wha", 10, "max webhook delivery attempts")
+	flag.DurationVar(&cfg.WebhookRetryBaseDelay, "whb", 10*time.Second, "base delay before next webhook attempt")
+	flag.DurationVar(&cfg.WebhookRetryMaxDelay, "whm", time.Hour, "max delay before next webhook attempt")
+	flag.DurationVar(&cfg.PointsTTL, "pt", 0, "accrued points ttl, 0 - points never expire")
+	flag.DurationVar(&cfg.PointsExpireInterval, "pei", time.Hour, "expired points check interval")
+	flag.DurationVar(&cfg.PointsExpiringWindow, "pew", 30*24*time.Hour, "show points expiring within this period in balance")
+	flag.TextVar(&cfg.TransferMinSum, "tmin", 1*models.MoneyScale, "min points in one transfer")
+	flag.TextVar(&cfg.TransferMaxSum, "tmax", 10000*models.MoneyScale, "max points in one transfer, 0 - no limit")
+	flag.TextVar(&cfg.TransferDailyLimit, "tdl", 50000*models.MoneyScale, "max points transferred per day, 0 - no limit")
+	flag.DurationVar(&cfg.HoldTTL, "ht", 30*time.Minute, "points hold ttl")
+	flag.DurationVar(&cfg.HoldReleaseInterval, "hri", time.Minute, "stale holds release interval")
+	flag.DurationVar(&cfg.EventsTTL, "et", 7*24*time.Hour, "user events ttl")
+	flag.DurationVar(&cfg.EventsHeartbeat, "eh", 15*time.Second, "events stream heartbeat interval")
+	flag.DurationVar(&cfg.IdempotencyTTL, "it", 24*time.Hour, "idempotency key ttl")
+	flag.DurationVar(&cfg.IdempotencyLockTTL, "ilt", time.Minute, "max time of request with idempotency key")
+	flag.DurationVar(&cfg.HealthMaxTickAge, "hta", 30*time.Second, "max age of last orders processor tick")
+	flag.Float64Var(&cfg.HealthMaxAccrualErrorRate, "her", 0.5, "max accrual error rate for readiness")
+	flag.DurationVar(&cfg.ShutdownDrain, "sd", 3*time.Second, "readiness drain delay before shutdown")
+	flag.StringVar(&cfg.TraceExporter, "te", "none", "trace exporter: none, stdout or otlp")
+	flag.StringVar(&cfg.TraceOTLPEndpoint, "tee", "", "otlp http endpoint")
+	flag.StringVar(&cfg.AdminTokens, "admin-tokens", "", "admin tokens: name1:token1,name2:token2")
+	flag.StringVar(&cfg.MerchantTokens, "mt", "", "merchant tokens: name1:token1,name2:token2")
 	flag.Parse()
 
 	if err := env.Parse(&cfg); err != nil {
@@ -45,12 +202,99 @@ func NewConfig() (*Config, error) {
 	}
 	cfg.Port = uint16(portInt)
 
-	if cfg.DatabaseDSN == "" {
-		return nil, errors.New("dsn is required")
+	switch cfg.StorageType {
+	case StoragePostgres:
+		if cfg.DatabaseDSN == "" {
+			return nil, errors.New("dsn is required")
+		}
+	case StorageMemory:
+	default:
+		return nil, fmt.Errorf("unknown storage type %q", cfg.StorageType)
 	}
 
 	if cfg.AccrualAddress == "" {
 		return nil, errors.New("accrual service address is required")
 	}
+
+	if cfg.SessionTTL <= 0 {
+		return nil, errors.New("session ttl must be positive")
+	}
+	if cfg.AccessTokenTTL <= 0 {
+		return nil, errors.New("access token ttl must be positive")
+	}
+
+	if cfg.AccrualTimeout <= 0 {
+		return nil, errors.New("accrual timeout must be positive")
+	}
+	if cfg.AccrualBreakerThreshold <= 0 {
+		return nil, errors.New("accrual breaker threshold must be positive")
+	}
+	if cfg.RetryBaseDelay <= 0 || cfg.RetryMaxDelay < cfg.RetryBaseDelay {
+		return nil, errors.New("bad retry delays")
+	}
+	if cfg.Workers <= 0 {
+		return nil, errors.New("workers count must be positive")
+	}
+	if cfg.PollInterval <= 0 || cfg.FlushInterval <= 0 {
+		return nil, errors.New("poll and flush intervals must be positive")
+	}
+	if cfg.FlushBatchSize <= 0 {
+		return nil, errors.New("flush batch size must be positive")
+	}
+	if cfg.WebhookTimeout <= 0 {
+		return nil, errors.New("webhook timeout must be positive")
+	}
+	if cfg.WebhookBatchSize <= 0 || cfg.WebhookMaxAttempts <= 0 {
+		return nil, errors.New("webhook batch size and max attempts must be positive")
+	}
+	if cfg.WebhookRetryBaseDelay <= 0 || cfg.WebhookRetryMaxDelay < cfg.WebhookRetryBaseDelay {
+		return nil, errors.New("bad webhook retry delays")
+	}
+	if cfg.PointsTTL < 0 {
+		return nil, errors.New("points ttl must not be negative")
+	}
+	if cfg.PointsExpireInterval <= 0 || cfg.PointsExpiringWindow <= 0 {
+		return nil, errors.New("points expire interval and expiring window must be positive")
+	}
+	if cfg.TransferMinSum <= 0 {
+		return nil, errors.New("transfer min sum must be positive")
+	}
+	if cfg.TransferMaxSum < 0 || cfg.TransferMaxSum > 0 && cfg.TransferMaxSum < cfg.TransferMinSum {
+		return nil, errors.New("transfer max sum must be zero or not less than min sum")
+	}
+	if cfg.TransferDailyLimit < 0 {
+		return nil, errors.New("transfer daily limit must not be negative")
+	}
+	if cfg.HoldTTL <= 0 || cfg.HoldReleaseInterval <= 0 {
+		return nil, errors.New("hold ttl and release interval must be positive")
+	}
+	if cfg.EventsTTL <= 0 || cfg.EventsHeartbeat <= 0 {
+		return nil, errors.New("events ttl and heartbeat must be positive")
+	}
+	if cfg.IdempotencyTTL <= 0 || cfg.IdempotencyLockTTL <= 0 {
+		return nil, errors.New("idempotency ttl and lock ttl must be positive")
+	}
+	if cfg.IdempotencyLockTTL >= cfg.IdempotencyTTL {
+		return nil, errors.New("idempotency lock ttl must be less than ttl")
+	}
+	if cfg.HealthMaxTickAge <= cfg.PollInterval {
+		return nil, errors.New("health max tick age must be greater than poll interval")
+	}
+	if cfg.HealthMaxAccrualErrorRate <= 0 || cfg.HealthMaxAccrualErrorRate > 1 {
+		return nil, errors.New("health max accrual error rate must be in (0, 1]")
+	}
+	if cfg.ShutdownDrain < 0 {
+		return nil, errors.New("shutdown drain must not be negative")
+	}
+	if cfg.OrderMaxAttempts <= 0 || cfg.OrderMaxAge <= 0 {
+		return nil, errors.New("order max attempts and max age must be positive")
+	}
+
+	if err := cfg.loadSecrets(); err != nil {
+		return nil, err
+	}
+	if cfg.PasswordSecret == "" {
+		return nil, errors.New("legacy password secret must not be empty")
+	}
 	return &cfg, nil
 }
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type AuthKey struct {
+	ID     string
+	Secret string
+}
+
+// parseAuthKey разбирает строку вида id:secret
+func parseAuthKey(s string) (*AuthKey, error) {
+	id, secret, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok || id == "" || secret == "" {
+		return nil, fmt.Errorf("bad key format, use id:secret")
+	}
+	return &AuthKey{ID: id, Secret: secret}, nil
+}
+
+func parseAuthKeys(s string) ([]AuthKey, error) {
+	var keys []AuthKey
+	for _, item := range strings.Split(s, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		key, err := parseAuthKey(item)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, nil
+}
+
+// readAuthKeysFile файл с ключами: по одному id:secret на строку, # - комментарий
+func readAuthKeysFile(path string) ([]AuthKey, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed open keys file: %w", err)
+	}
+	defer f.Close()
+
+	var keys []AuthKey
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, err := parseAuthKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("keys file %s: %w", path, err)
+		}
+		keys = append(keys, *key)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed read keys file: %w", err)
+	}
+	return keys, nil
+}
+
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed read secret file: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (cfg *Config) loadSecrets() error {
+	keys, err := parseAuthKeys(cfg.CookieKeys)
+	if err != nil {
+		return err
+	}
+	cfg.AuthKeys = keys
+	if cfg.CookieKeysFile != "" {
+		keys, err := readAuthKeysFile(cfg.CookieKeysFile)
+		if err != nil {
+			return err
+		}
+		cfg.AuthKeys = append(cfg.AuthKeys, keys...)
+	}
+
+	if cfg.PasswordSecretFile != "" {
+		secret, err := readSecretFile(cfg.PasswordSecretFile)
+		if err != nil {
+			return err
+		}
+		cfg.PasswordSecret = secret
+	}
+
+	admins, err := parseAuthKeys(cfg.AdminTokens)
+	if err != nil {
+		return fmt.Errorf("admin tokens: %w", err)
+	}
+	cfg.Admins = admins
+
+	merchants, err := parseAuthKeys(cfg.MerchantTokens)
+	if err != nil {
+		return fmt.Errorf("merchant tokens: %w", err)
+	}
+	// имя попадает в журнал отмен, оно должно однозначно указывать на роль
+	for _, m := range merchants {
+		for _, adm := range admins {
+			if m.ID == adm.ID {
+				return fmt.Errorf("merchant %q has the same name as admin", m.ID)
+			}
+		}
+	}
+	cfg.Merchants = merchants
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/go-chi/chi/v5/middleware"
 	usercontext "github.com/serg2014/go-musthave-diploma/internal/app/context"
 	"go.uber.org/zap"
 )
@@ -61,6 +62,11 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	r.responseData.status = statusCode // захватываем код статуса
 }
 
+// Unwrap нужен http.ResponseController, например для Flush
+func (r *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
 // WithLogging добавляет дополнительный код для регистрации сведений о запросе
 // и возвращает новый http.Handler.
 func WithLogging(h http.Handler) http.Handler {
@@ -102,6 +108,7 @@ func WithLogging(h http.Handler) http.Handler {
 			zap.Int("status", responseData.status),
 			zap.Int("size", responseData.size),
 			zap.String("userID", userIDStr),
+			zap.String("request_id", middleware.GetReqID(r.Context())),
 		)
 
 	}
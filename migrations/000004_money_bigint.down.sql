@@ -0,0 +1,2 @@
+ALTER TABLE debet_credit ALTER COLUMN sum TYPE int;
+ALTER TABLE orders ALTER COLUMN accrual TYPE int;
@@ -0,0 +1,2 @@
+ALTER TABLE orders ALTER COLUMN accrual TYPE bigint;
+ALTER TABLE debet_credit ALTER COLUMN sum TYPE bigint;
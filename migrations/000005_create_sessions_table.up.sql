@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS sessions (
+    session_id uuid NOT NULL DEFAULT gen_random_uuid() PRIMARY KEY,
+    user_id uuid NOT NULL,
+    created_at timestamp NOT NULL DEFAULT current_timestamp,
+    expires_at timestamp NOT NULL,
+    revoked_at timestamp
+);
+CREATE INDEX sessions_user_id_idx ON sessions (user_id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS orders_for_process_who_lock_next_attempt_at_idx;
+ALTER TABLE orders_for_process DROP COLUMN IF EXISTS next_attempt_at;
+ALTER TABLE orders_for_process DROP COLUMN IF EXISTS attempts;
@@ -0,0 +1,3 @@
+ALTER TABLE orders_for_process ADD COLUMN IF NOT EXISTS attempts int NOT NULL DEFAULT 0;
+ALTER TABLE orders_for_process ADD COLUMN IF NOT EXISTS next_attempt_at timestamp NOT NULL DEFAULT current_timestamp;
+CREATE INDEX IF NOT EXISTS orders_for_process_who_lock_next_attempt_at_idx ON orders_for_process (who_lock, next_attempt_at);
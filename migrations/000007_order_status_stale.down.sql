@@ -0,0 +1,2 @@
+-- значение из enum удалить нельзя, возвращаем такие заказы в NEW
+UPDATE orders SET status = 'NEW' WHERE status = 'STALE';
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS orders_dead_letter;
+ALTER TABLE orders_for_process DROP COLUMN IF EXISTS created_at;
@@ -0,0 +1,11 @@
+ALTER TABLE orders_for_process ADD COLUMN IF NOT EXISTS created_at timestamp NOT NULL DEFAULT current_timestamp;
+
+CREATE TABLE IF NOT EXISTS orders_dead_letter (
+    order_id text NOT NULL PRIMARY KEY,
+    user_id uuid NOT NULL,
+    attempts int NOT NULL,
+    last_error text NOT NULL,
+    created_at timestamp NOT NULL,
+    dead_at timestamp NOT NULL DEFAULT current_timestamp
+);
+CREATE INDEX orders_dead_letter_dead_at_idx ON orders_dead_letter (dead_at);
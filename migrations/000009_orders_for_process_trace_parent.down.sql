@@ -0,0 +1 @@
+ALTER TABLE orders_for_process DROP COLUMN IF EXISTS trace_parent;
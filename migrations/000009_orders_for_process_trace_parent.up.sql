@@ -0,0 +1 @@
+ALTER TABLE orders_for_process ADD COLUMN IF NOT EXISTS trace_parent text NOT NULL DEFAULT '';
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id uuid NOT NULL,
+    key text NOT NULL,
+    fingerprint text NOT NULL,
+    -- NULL пока запрос выполняется
+    status int,
+    content_type text,
+    response bytea,
+    created_at timestamp NOT NULL DEFAULT current_timestamp,
+    expires_at timestamp NOT NULL,
+    PRIMARY KEY (user_id, key)
+);
+CREATE INDEX idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS webhooks (
+    user_id uuid NOT NULL PRIMARY KEY,
+    url text NOT NULL,
+    -- ключ HMAC подписи, нужен в открытом виде
+    secret text NOT NULL,
+    created_at timestamp NOT NULL DEFAULT current_timestamp
+);
+
+DO $$ BEGIN
+    CREATE TYPE webhook_delivery_status AS ENUM ('PENDING', 'DELIVERED', 'FAILED');
+EXCEPTION
+    WHEN duplicate_object THEN null;
+END $$;
+
+-- outbox: события пишутся в одной транзакции с изменением заказа,
+-- доставляет их отдельный воркер. Строки остаются как журнал доставки
+CREATE TABLE IF NOT EXISTS webhook_outbox (
+    id bigserial PRIMARY KEY,
+    user_id uuid NOT NULL,
+    event text NOT NULL,
+    payload jsonb NOT NULL,
+    status webhook_delivery_status NOT NULL DEFAULT 'PENDING',
+    attempts int NOT NULL DEFAULT 0,
+    next_attempt_at timestamp NOT NULL DEFAULT current_timestamp,
+    who_lock char(20),
+    locked_at timestamp,
+    last_status_code int,
+    last_error text,
+    created_at timestamp NOT NULL DEFAULT current_timestamp,
+    delivered_at timestamp
+);
+CREATE INDEX webhook_outbox_pending_idx ON webhook_outbox (next_attempt_at) WHERE status = 'PENDING';
+CREATE INDEX webhook_outbox_user_id_created_at_idx ON webhook_outbox (user_id, created_at);
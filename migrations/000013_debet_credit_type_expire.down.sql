@@ -0,0 +1,2 @@
+-- значение из enum удалить нельзя, удаляем записи о сгоревших баллах
+DELETE FROM debet_credit WHERE type = 'EXPIRE';
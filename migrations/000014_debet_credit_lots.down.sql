@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS debet_credit_expire_at_idx;
+ALTER TABLE debet_credit DROP COLUMN IF EXISTS expire_at;
+ALTER TABLE debet_credit DROP COLUMN IF EXISTS remaining;
@@ -0,0 +1,23 @@
+-- начисление (DEBET) - партия баллов: сколько еще не списано и когда сгорит.
+-- NULL в expire_at - не сгорает, так остаются начисления до этой миграции
+ALTER TABLE debet_credit ADD COLUMN IF NOT EXISTS remaining bigint;
+ALTER TABLE debet_credit ADD COLUMN IF NOT EXISTS expire_at timestamp;
+
+-- уже сделанные списания гасят партии от старых к новым
+WITH credited AS (
+    SELECT user_id, sum("sum") AS total
+    FROM debet_credit
+    WHERE type = 'CREDIT'
+    GROUP BY user_id
+), lots AS (
+    SELECT order_id, user_id, "sum",
+           sum("sum") OVER (PARTITION BY user_id ORDER BY create_time, order_id) AS cum
+    FROM debet_credit
+    WHERE type = 'DEBET'
+)
+UPDATE debet_credit AS d
+SET remaining = GREATEST(0, LEAST(lots."sum", lots.cum - coalesce(credited.total, 0)))
+FROM lots LEFT JOIN credited ON credited.user_id = lots.user_id
+WHERE d.order_id = lots.order_id AND d.type = 'DEBET';
+
+CREATE INDEX debet_credit_expire_at_idx ON debet_credit (expire_at) WHERE type = 'DEBET' AND remaining > 0;
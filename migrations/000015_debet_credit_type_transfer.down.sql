@@ -0,0 +1,2 @@
+-- значение из enum удалить нельзя, удаляем записи о переводах
+DELETE FROM debet_credit WHERE type IN ('TRANSFER_OUT', 'TRANSFER_IN');
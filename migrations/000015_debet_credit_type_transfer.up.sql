@@ -0,0 +1,2 @@
+ALTER TYPE debet_credit_type ADD VALUE IF NOT EXISTS 'TRANSFER_OUT';
+ALTER TYPE debet_credit_type ADD VALUE IF NOT EXISTS 'TRANSFER_IN';
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS debet_credit_expire_at_idx;
+CREATE INDEX debet_credit_expire_at_idx ON debet_credit (expire_at) WHERE type = 'DEBET' AND remaining > 0;
+DROP TABLE IF EXISTS transfers;
@@ -0,0 +1,15 @@
+-- перевод баллов между пользователями. В debet_credit ему соответствуют
+-- TRANSFER_OUT отправителя и TRANSFER_IN получателя с order_id = id
+CREATE TABLE IF NOT EXISTS transfers (
+    id uuid NOT NULL PRIMARY KEY,
+    from_user_id uuid NOT NULL,
+    to_user_id uuid NOT NULL,
+    sum bigint NOT NULL,
+    created_at timestamp NOT NULL DEFAULT current_timestamp
+);
+CREATE INDEX transfers_from_user_id_created_at_idx ON transfers (from_user_id, created_at);
+CREATE INDEX transfers_to_user_id_created_at_idx ON transfers (to_user_id, created_at);
+
+-- полученный перевод такая же партия баллов, как начисление
+DROP INDEX IF EXISTS debet_credit_expire_at_idx;
+CREATE INDEX debet_credit_expire_at_idx ON debet_credit (expire_at) WHERE remaining > 0;
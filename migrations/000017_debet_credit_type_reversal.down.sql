@@ -0,0 +1,2 @@
+-- значение из enum удалить нельзя, удаляем записи об отмене списаний
+DELETE FROM debet_credit WHERE type = 'REVERSAL';
@@ -0,0 +1,10 @@
+-- кто и почему отменил списание. Баллы возвращаются записью REVERSAL
+-- в debet_credit с тем же order_id
+CREATE TABLE IF NOT EXISTS withdrawal_reversals (
+    order_id text NOT NULL PRIMARY KEY,
+    user_id uuid NOT NULL,
+    sum bigint NOT NULL,
+    reversed_by text NOT NULL,
+    reason text NOT NULL DEFAULT '',
+    created_at timestamp NOT NULL DEFAULT current_timestamp
+);
@@ -0,0 +1,2 @@
+-- значение из enum удалить нельзя, удаляем записи о резервах
+DELETE FROM debet_credit WHERE type IN ('HOLD', 'RELEASE');
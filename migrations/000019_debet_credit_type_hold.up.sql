@@ -0,0 +1,2 @@
+ALTER TYPE debet_credit_type ADD VALUE IF NOT EXISTS 'HOLD';
+ALTER TYPE debet_credit_type ADD VALUE IF NOT EXISTS 'RELEASE';
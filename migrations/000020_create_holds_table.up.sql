@@ -0,0 +1,20 @@
+DO $$ BEGIN
+    CREATE TYPE hold_status AS ENUM ('HELD', 'CAPTURED', 'RELEASED');
+EXCEPTION
+    WHEN duplicate_object THEN null;
+END $$;
+
+-- резерв баллов под заказ. Баллы списываются записью HOLD в debet_credit,
+-- при подтверждении появляется CREDIT, при отмене - RELEASE с тем же order_id
+CREATE TABLE IF NOT EXISTS holds (
+    order_id text NOT NULL PRIMARY KEY,
+    user_id uuid NOT NULL,
+    sum bigint NOT NULL,
+    status hold_status NOT NULL DEFAULT 'HELD',
+    -- после этого времени резерв снимается автоматически
+    expires_at timestamp NOT NULL,
+    created_at timestamp NOT NULL DEFAULT current_timestamp,
+    finished_at timestamp
+);
+CREATE INDEX holds_user_id_status_idx ON holds (user_id, status);
+CREATE INDEX holds_expires_at_idx ON holds (expires_at) WHERE status = 'HELD';
@@ -0,0 +1 @@
+ALTER TYPE debet_credit_type ADD VALUE IF NOT EXISTS 'ADJUSTMENT';
@@ -0,0 +1,11 @@
+-- ручные корректировки баланса администратором. В debet_credit им соответствует
+-- ADJUSTMENT с order_id = id: sum больше нуля - начисление, меньше - списание
+CREATE TABLE IF NOT EXISTS balance_adjustments (
+    id uuid NOT NULL PRIMARY KEY,
+    user_id uuid NOT NULL,
+    sum bigint NOT NULL,
+    reason text NOT NULL,
+    admin text NOT NULL,
+    created_at timestamp NOT NULL DEFAULT current_timestamp
+);
+CREATE INDEX balance_adjustments_user_id_created_at_idx ON balance_adjustments (user_id, created_at);
@@ -0,0 +1,6 @@
+-- до какого времени ключ занят выполняющимся запросом. После этого зависший
+-- ключ (процесс упал или обработчик паникует) может занять повтор того же запроса
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS locked_until timestamp;
+
+-- ключи, которые выполнялись до этой миграции, уже никто не завершит
+UPDATE idempotency_keys SET locked_until = created_at WHERE status IS NULL;
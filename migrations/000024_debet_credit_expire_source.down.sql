@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS debet_credit_expire_source_idx;
+ALTER TABLE debet_credit DROP COLUMN IF EXISTS source_type;
+ALTER TABLE debet_credit DROP COLUMN IF EXISTS source_order_id;
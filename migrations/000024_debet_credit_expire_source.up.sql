@@ -0,0 +1,10 @@
+-- EXPIRE пишется на каждую сгоревшую партию под своим id. Партия, из которой
+-- сгорели баллы, в source_order_id и source_type: у партий разных пользователей
+-- и разных типов может быть один order_id
+ALTER TABLE debet_credit ADD COLUMN IF NOT EXISTS source_order_id text;
+ALTER TABLE debet_credit ADD COLUMN IF NOT EXISTS source_type debet_credit_type;
+
+-- до этой миграции EXPIRE писались под номером заказа партии
+UPDATE debet_credit SET source_order_id = order_id WHERE type = 'EXPIRE';
+
+CREATE UNIQUE INDEX debet_credit_expire_source_idx ON debet_credit (source_order_id, source_type) WHERE type = 'EXPIRE';
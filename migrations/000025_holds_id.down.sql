@@ -0,0 +1,5 @@
+UPDATE debet_credit AS d SET order_id = h.order_id
+FROM holds AS h
+WHERE d.order_id = h.id::text AND d.type IN ('HOLD', 'RELEASE');
+
+ALTER TABLE holds DROP COLUMN IF EXISTS id;
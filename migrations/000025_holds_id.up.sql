@@ -0,0 +1,7 @@
+-- у каждого резерва свой id: записи HOLD и RELEASE в debet_credit пишутся под ним,
+-- чтобы после снятия резерва заказ можно было зарезервировать снова
+ALTER TABLE holds ADD COLUMN IF NOT EXISTS id uuid NOT NULL DEFAULT gen_random_uuid();
+
+UPDATE debet_credit AS d SET order_id = h.id::text
+FROM holds AS h
+WHERE d.order_id = h.order_id AND d.user_id = h.user_id AND d.type IN ('HOLD', 'RELEASE');